@@ -0,0 +1,109 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "io"
+
+// StripANSI returns a writer that writes to w, removing any ANSI SGR
+// (Select Graphic Rendition) escape sequences, such as "\x1b[31m",
+// which are used to set colors and text attributes. All other content,
+// including other escape sequences, is passed through unchanged.
+//
+// A sequence may be split across calls to Write, so the writer holds
+// back an incomplete sequence at the end of a Write until the next
+// one. The returned writer has a Flush method, called by
+// [Handler.Flush], that writes any such bytes and then calls the Flush
+// method of w, if it has one.
+//
+// The returned writer is not safe for concurrent use, but
+// the Handler serializes its calls to Write and Flush.
+//
+// To write colors only to terminals, use [ColorAuto] instead.
+func StripANSI(w io.Writer) io.Writer {
+	return &ansiStripper{w: w}
+}
+
+type ansiStripper struct {
+	w io.Writer
+	// pending holds a possible SGR sequence that
+	// has not yet been terminated.
+	pending []byte
+	out     []byte
+}
+
+const (
+	ansiESC = 0x1b
+	ansiCSI = '['
+)
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := s.out[:0]
+	for _, c := range p {
+		if len(s.pending) == 0 {
+			if c == ansiESC {
+				s.pending = append(s.pending, c)
+			} else {
+				out = append(out, c)
+			}
+			continue
+		}
+		switch {
+		case len(s.pending) == 1:
+			if c != ansiCSI {
+				// Not a control sequence.
+				out = s.reject(out, c)
+				continue
+			}
+		case c == 'm':
+			// End of SGR sequence: drop it.
+			s.pending = s.pending[:0]
+			continue
+		case '0' <= c && c <= '9' || c == ';':
+			// SGR parameter byte: keep going.
+		default:
+			// Some other sequence: pass it through.
+			out = s.reject(out, c)
+			continue
+		}
+		s.pending = append(s.pending, c)
+	}
+	s.out = out
+	if len(out) > 0 {
+		if _, err := s.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// reject appends the pending bytes to out, followed by c unless
+// c is an escape character, which may start a new sequence.
+func (s *ansiStripper) reject(out []byte, c byte) []byte {
+	out = append(out, s.pending...)
+	s.pending = s.pending[:0]
+	if c == ansiESC {
+		s.pending = append(s.pending, c)
+	} else {
+		out = append(out, c)
+	}
+	return out
+}
+
+// Flush writes any bytes held back because they might start an
+// SGR sequence, then calls the Flush method of the underlying writer
+// if it has one.
+func (s *ansiStripper) Flush() error {
+	if len(s.pending) > 0 {
+		_, err := s.w.Write(s.pending)
+		s.pending = s.pending[:0]
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := s.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
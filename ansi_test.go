@@ -0,0 +1,68 @@
+package slogtext
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   []string
+		want string
+	}{
+		{"plain", []string{"level=INFO msg=m\n"}, "level=INFO msg=m\n"},
+		{"color", []string{"level=\x1b[31mERROR\x1b[0m msg=m\n"}, "level=ERROR msg=m\n"},
+		{"multiple params", []string{"\x1b[1;33mWARN\x1b[m"}, "WARN"},
+		{"split across writes", []string{"a\x1b[3", "1mb\x1b", "[0mc"}, "abc"},
+		{"other CSI sequence", []string{"a\x1b[2Kb"}, "a\x1b[2Kb"},
+		{"lone escape", []string{"a\x1bxb"}, "a\x1bxb"},
+		{"quoted escape", []string{`msg="\x1b[31m"`}, `msg="\x1b[31m"`},
+		{"double escape", []string{"a\x1b\x1b[1mb"}, "a\x1bb"},
+		{"escape in sequence", []string{"a\x1b[3\x1b[1mb"}, "a\x1b[3b"},
+		{"escape after other sequence", []string{"a\x1b[2\x1b", "[1mb"}, "a\x1b[2b"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := StripANSI(&buf)
+			for _, s := range test.in {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(s) {
+					t.Errorf("got n=%d, want %d", n, len(s))
+				}
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStripANSIFlush(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	h := NewHandler(StripANSI(bw))
+	w := h.w
+	for _, s := range []string{"a\x1b[31mb\x1b", "[0mc\x1b[1"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "abc\x1b[1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Nothing is written twice.
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "abc\x1b[1"; got != want {
+		t.Errorf("after second Flush: got %q, want %q", got, want)
+	}
+}
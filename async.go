@@ -0,0 +1,183 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/slog"
+)
+
+// ErrAsyncClosed is returned by [AsyncHandler.Handle] when
+// the handler has been closed.
+var ErrAsyncClosed = errors.New("slogtext: async handler closed")
+
+// AsyncOptions holds options for an AsyncHandler.
+type AsyncOptions struct {
+	// BufferSize holds the number of formatted records
+	// that can be queued before they are written.
+	BufferSize int
+
+	// DropWhenFull causes Handle to drop records that cannot
+	// be queued immediately, counting them; see
+	// [AsyncHandler.Dropped]. Otherwise Handle waits until
+	// the record can be queued or the context is done.
+	DropWhenFull bool
+}
+
+// AsyncHandler is a [slog.Handler] that formats records
+// in the caller's goroutine but writes them from a single
+// separate goroutine, so that callers need not wait for the
+// lock on the writer or for Write itself.
+type AsyncHandler struct {
+	h *Handler
+	q *asyncQueue // shared by all derived handlers
+}
+
+// asyncQueue holds the records waiting to be written.
+type asyncQueue struct {
+	opts    AsyncOptions
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	// mu guards closed and the sending on ch, so that
+	// ch is never closed while a record is being sent.
+	mu     sync.RWMutex
+	closed bool
+	ch     chan asyncRecord
+
+	// err holds the first error returned by a write.
+	// It is not accessed until done is closed.
+	err error
+}
+
+// asyncRecord holds a formatted record and the handler
+// that formatted it.
+type asyncRecord struct {
+	h     *Handler
+	level slog.Level
+	buf   *buffer
+}
+
+// NewAsyncHandler is like [NewAsyncHandlerWithOptions] with
+// the BufferSize option set to bufSize.
+func NewAsyncHandler(h *Handler, bufSize int) (*AsyncHandler, func() error) {
+	return NewAsyncHandlerWithOptions(h, AsyncOptions{BufferSize: bufSize})
+}
+
+// NewAsyncHandlerWithOptions returns a handler that formats records
+// with h and queues them to be written by a new goroutine. It also
+// returns a function that closes the handler and all those derived
+// from it, waits for the queued records to be written and returns
+// the first error returned by a write, if any. Calls to Handle after
+// that return [ErrAsyncClosed]. If [Options.OnError] is set, it is
+// called from the writing goroutine for every write that fails.
+func NewAsyncHandlerWithOptions(h *Handler, opts AsyncOptions) (*AsyncHandler, func() error) {
+	if opts.BufferSize < 0 {
+		panic("slogtext: negative BufferSize")
+	}
+	q := &asyncQueue{
+		opts: opts,
+		done: make(chan struct{}),
+		ch:   make(chan asyncRecord, opts.BufferSize),
+	}
+	go q.run()
+	return &AsyncHandler{h: h, q: q}, q.close
+}
+
+func (q *asyncQueue) run() {
+	defer close(q.done)
+	for rec := range q.ch {
+		if _, err := rec.h.write(rec.level, *rec.buf); err != nil {
+			if q.err == nil {
+				q.err = err
+			}
+			if f := rec.h.opts.OnError; f != nil {
+				f(err)
+			}
+		}
+		rec.h.freeRecordBuffer(rec.buf)
+	}
+}
+
+func (q *asyncQueue) close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+	return q.err
+}
+
+// send queues rec, reporting whether it did so.
+func (q *asyncQueue) send(ctx context.Context, rec asyncRecord) (bool, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false, ErrAsyncClosed
+	}
+	if q.opts.DropWhenFull {
+		select {
+		case q.ch <- rec:
+			return true, nil
+		default:
+			q.dropped.Add(1)
+			return false, nil
+		}
+	}
+	if ctx == nil {
+		// Handler tolerates a nil context, so we do too.
+		q.ch <- rec
+		return true, nil
+	}
+	select {
+	case q.ch <- rec:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Dropped returns the number of records that have been dropped
+// by h and all handlers derived from it because they could not
+// be queued immediately.
+func (h *AsyncHandler) Dropped() uint64 {
+	return h.q.dropped.Load()
+}
+
+// Enabled implements [slog.Handler.Enabled].
+func (h *AsyncHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+// WithAttrs implements [slog.Handler.WithAttrs].
+func (h *AsyncHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &AsyncHandler{h: h.h.withAttrs(attrs), q: h.q}
+}
+
+// WithGroup implements [slog.Handler.WithGroup].
+func (h *AsyncHandler) WithGroup(name string) slog.Handler {
+	return &AsyncHandler{h: h.h.withGroup(name), q: h.q}
+}
+
+// Handle implements [slog.Handler.Handle] by formatting r
+// and queuing the result to be written.
+func (h *AsyncHandler) Handle(ctx context.Context, r slog.Record) error {
+	buf := h.h.newRecordBuffer()
+	if !h.h.format(buf, ctx, r) {
+		h.h.freeRecordBuffer(buf)
+		return nil
+	}
+	ok, err := h.q.send(ctx, asyncRecord{h: h.h, level: r.Level, buf: buf})
+	if !ok {
+		h.h.freeRecordBuffer(buf)
+	}
+	return err
+}
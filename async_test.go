@@ -0,0 +1,180 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestAsyncHandlerOrder(t *testing.T) {
+	var buf bytes.Buffer
+	h, closeHandler := NewAsyncHandler(NewHandler(&buf), 4)
+	l := slog.New(h).With("a", 1).WithGroup("g")
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		l.Info("m", "i", i)
+		fmt.Fprintf(&want, "level=INFO msg=m a=1 g.i=%d\n", i)
+	}
+	if err := closeHandler(); err != nil {
+		t.Fatal(err)
+	}
+	if got := removeTimes(buf.String()); got != want.String() {
+		t.Errorf("got\n%s\nwant\n%s", got, want.String())
+	}
+}
+
+func TestAsyncHandlerClose(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h, closeHandler := NewAsyncHandler(NewHandler(w), 10)
+	handle := func(msg string) error {
+		return h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, msg, 0))
+	}
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Closing must wait for the queued records to be written.
+	closed := make(chan error)
+	go func() {
+		closed <- closeHandler()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("close returned before the records were written")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(w.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "level=INFO msg=a\nlevel=INFO msg=b\nlevel=INFO msg=c\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := handle("d"); err != ErrAsyncClosed {
+		t.Errorf("got error %v after close, want ErrAsyncClosed", err)
+	}
+	// Closing again does nothing.
+	if err := closeHandler(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncHandlerDropWhenFull(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h, closeHandler := NewAsyncHandlerWithOptions(NewHandler(w), AsyncOptions{
+		BufferSize:   2,
+		DropWhenFull: true,
+	})
+	for i := 0; i < 10; i++ {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The writer goroutine may hold one record as well
+	// as the two that are queued.
+	if n := h.Dropped(); n != 7 && n != 8 {
+		t.Errorf("got %d dropped records, want 7 or 8", n)
+	}
+	close(w.release)
+	if err := closeHandler(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(w.String(), "\n"), 10-int(h.Dropped()); got != want {
+		t.Errorf("got %d lines, want %d", got, want)
+	}
+}
+
+func TestAsyncHandlerBlockContext(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h, closeHandler := NewAsyncHandler(NewHandler(w), 0)
+	defer closeHandler()
+	defer close(w.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = h.Handle(ctx, slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestAsyncHandlerNilContext(t *testing.T) {
+	var buf bytes.Buffer
+	h, closeHandler := NewAsyncHandler(NewHandler(&buf), 0)
+	if err := h.Handle(nil, slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeHandler(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAsyncHandlerWriteError(t *testing.T) {
+	errWrite := errors.New("cannot write")
+	h, closeHandler := NewAsyncHandler(NewHandler(errorWriter{errWrite}), 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+		}()
+	}
+	wg.Wait()
+	if err := closeHandler(); err != errWrite {
+		t.Errorf("got error %v, want %v", err, errWrite)
+	}
+}
+
+func TestAsyncHandlerOnError(t *testing.T) {
+	errWrite := errors.New("cannot write")
+	var mu sync.Mutex
+	var errs []error
+	h, closeHandler := NewAsyncHandler(NewHandlerWithOptions(errorWriter{errWrite}, Options{
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	}), 3)
+	for i := 0; i < 3; i++ {
+		if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := closeHandler(); err != errWrite {
+		t.Errorf("got error %v, want %v", err, errWrite)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 3 {
+		t.Fatalf("got %d calls to OnError, want 3", len(errs))
+	}
+	for _, err := range errs {
+		if err != errWrite {
+			t.Errorf("got error %v, want %v", err, errWrite)
+		}
+	}
+}
+
+type errorWriter struct {
+	err error
+}
+
+func (w errorWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
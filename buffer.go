@@ -10,9 +10,11 @@ import "sync"
 type buffer []byte
 
 // Having an initial size gives a dramatic speedup.
+const initialBufferSize = 1024
+
 var bufPool = sync.Pool{
 	New: func() any {
-		b := make([]byte, 0, 1024)
+		b := make([]byte, 0, initialBufferSize)
 		return (*buffer)(&b)
 	},
 }
@@ -21,15 +23,48 @@ func newBuffer() *buffer {
 	return bufPool.Get().(*buffer)
 }
 
+// To reduce peak allocation, return only smaller buffers to the pool.
+const maxBufferSize = 16 << 10
+
 func (b *buffer) Free() {
-	// To reduce peak allocation, return only smaller buffers to the pool.
-	const maxbufferSize = 16 << 10
-	if cap(*b) <= maxbufferSize {
+	if cap(*b) <= maxBufferSize {
 		*b = (*b)[:0]
 		bufPool.Put(b)
 	}
 }
 
+// bufferPool is a pool of buffers of a given initial size,
+// used instead of bufPool by handlers that configure their buffers.
+type bufferPool struct {
+	pool    sync.Pool
+	maxSize int
+}
+
+// newBufferPool returns a pool of buffers with the given
+// initial capacity that keeps buffers of up to maxSize bytes.
+func newBufferPool(initialSize, maxSize int) *bufferPool {
+	return &bufferPool{
+		pool: sync.Pool{
+			New: func() any {
+				b := make([]byte, 0, initialSize)
+				return (*buffer)(&b)
+			},
+		},
+		maxSize: maxSize,
+	}
+}
+
+func (p *bufferPool) get() *buffer {
+	return p.pool.Get().(*buffer)
+}
+
+func (p *bufferPool) put(b *buffer) {
+	if cap(*b) <= p.maxSize {
+		*b = (*b)[:0]
+		p.pool.Put(b)
+	}
+}
+
 func (b *buffer) Reset() {
 	*b = (*b)[:0]
 }
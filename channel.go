@@ -0,0 +1,166 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Record holds a record sent by a [ChannelHandler].
+type Record struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	// Source holds the source location as FILE:LINE,
+	// if AddSource was set and it is available.
+	Source string
+	// Attrs holds the resolved attributes of the record,
+	// including those added with WithAttrs. Groups
+	// started with WithGroup are represented as
+	// nested group attributes.
+	Attrs []slog.Attr
+}
+
+// ChannelOptions holds options for a ChannelHandler.
+type ChannelOptions struct {
+	// Level reports the minimum level to send.
+	// If nil, slog.LevelInfo is used.
+	Level slog.Leveler
+
+	// AddSource causes the source location of each
+	// record to be included.
+	AddSource bool
+
+	// Block causes Handle to wait until the record
+	// can be sent or the context is done. Otherwise
+	// records that cannot be sent immediately are dropped
+	// and counted; see [ChannelHandler.Dropped].
+	Block bool
+}
+
+// ChannelHandler is a [slog.Handler] that sends records on a channel
+// for processing within the same program, without formatting them.
+type ChannelHandler struct {
+	ch      chan<- Record
+	opts    ChannelOptions
+	goas    []groupOrAttrs
+	dropped *atomic.Uint64 // shared by all derived handlers
+}
+
+// groupOrAttrs holds either a group name or a set of attributes.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewChannelHandler returns a handler that sends each record on ch.
+func NewChannelHandler(ch chan<- Record, opts ChannelOptions) *ChannelHandler {
+	return &ChannelHandler{
+		ch:      ch,
+		opts:    opts,
+		dropped: new(atomic.Uint64),
+	}
+}
+
+// Dropped returns the number of records that have been dropped
+// by h and all handlers derived from it because they could not
+// be sent immediately.
+func (h *ChannelHandler) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Enabled implements [slog.Handler.Enabled].
+func (h *ChannelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+// WithAttrs implements [slog.Handler.WithAttrs].
+func (h *ChannelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(groupOrAttrs{attrs: resolveAttrs(attrs)})
+}
+
+// WithGroup implements [slog.Handler.WithGroup].
+func (h *ChannelHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *ChannelHandler) with(goa groupOrAttrs) *ChannelHandler {
+	h2 := *h
+	h2.goas = append(slices.Clip(h.goas), goa)
+	return &h2
+}
+
+// Handle implements [slog.Handler.Handle] by sending
+// the record on the channel.
+func (h *ChannelHandler) Handle(ctx context.Context, r slog.Record) error {
+	rec := Record{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+	}
+	if h.opts.AddSource {
+		if f := recordFrame(r); f.File != "" {
+			rec.Source = f.File + ":" + strconv.Itoa(f.Line)
+		}
+	}
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) {
+		attrs = append(attrs, resolveAttr(a))
+	})
+	rec.Attrs = nestAttrs(h.goas, attrs)
+	if h.opts.Block {
+		if ctx == nil {
+			// Handler tolerates a nil context, so we do too.
+			h.ch <- rec
+			return nil
+		}
+		select {
+		case h.ch <- rec:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case h.ch <- rec:
+	default:
+		h.dropped.Add(1)
+	}
+	return nil
+}
+
+func resolveAttrs(attrs []slog.Attr) []slog.Attr {
+	resolved := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		resolved[i] = resolveAttr(a)
+	}
+	return resolved
+}
+
+// resolveAttr resolves the value of a, including
+// the values inside any groups.
+func resolveAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		a.Value = slog.GroupValue(resolveAttrs(a.Value.Group())...)
+	}
+	return a
+}
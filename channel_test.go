@@ -0,0 +1,98 @@
+package slogtext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestChannelHandler(t *testing.T) {
+	ch := make(chan Record, 1)
+	var h slog.Handler = NewChannelHandler(ch, ChannelOptions{})
+	h = h.WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("g")
+	r := slog.NewRecord(testTime, slog.LevelWarn, "m", 0)
+	r.AddAttrs(slog.Any("name", logValueName{"Ren", "Hoek"}), slog.Int("a", 2))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	rec := <-ch
+	if rec.Time != testTime || rec.Level != slog.LevelWarn || rec.Message != "m" || rec.Source != "" {
+		t.Errorf("unexpected record fields %+v", rec)
+	}
+	want := []slog.Attr{
+		slog.Int("p", 1),
+		slog.Group("g",
+			slog.Group("name", slog.String("first", "Ren"), slog.String("last", "Hoek")),
+			slog.Int("a", 2),
+		),
+	}
+	if !attrsEqual(rec.Attrs, want) {
+		t.Errorf("got attrs %v, want %v", rec.Attrs, want)
+	}
+}
+
+func TestChannelHandlerNonBlocking(t *testing.T) {
+	ch := make(chan Record, 1)
+	h := NewChannelHandler(ch, ChannelOptions{})
+	h2 := h.WithGroup("g").(*ChannelHandler)
+	for i := 0; i < 3; i++ {
+		if err := h2.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := h.Dropped(); got != 2 {
+		t.Errorf("got %d dropped, want 2", got)
+	}
+	if len(ch) != 1 {
+		t.Errorf("got %d records in channel, want 1", len(ch))
+	}
+}
+
+func TestChannelHandlerBlocking(t *testing.T) {
+	ch := make(chan Record)
+	h := NewChannelHandler(ch, ChannelOptions{Block: true, AddSource: true})
+	done := make(chan error)
+	go func() {
+		done <- h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", callerPC(2)))
+	}()
+	rec := <-ch
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rec.Source == "" {
+		t.Errorf("no source in record")
+	}
+
+	// A nil context is treated as one that is never canceled.
+	go func() {
+		done <- h.Handle(nil, slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+	}()
+	<-ch
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A canceled context unblocks Handle.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h.Handle(ctx, slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if h.Dropped() != 0 {
+		t.Errorf("blocking handler dropped records")
+	}
+}
+
+func attrsEqual(as1, as2 []slog.Attr) bool {
+	if len(as1) != len(as2) {
+		return false
+	}
+	for i, a := range as1 {
+		if !a.Equal(as2[i]) {
+			return false
+		}
+	}
+	return true
+}
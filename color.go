@@ -0,0 +1,106 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/exp/slog"
+)
+
+// ColorMode determines whether a Handler colorizes its output
+// with ANSI escape sequences.
+type ColorMode int
+
+const (
+	// ColorNever disables color. This is the default.
+	ColorNever ColorMode = iota
+	// ColorAlways enables color regardless of the writer.
+	ColorAlways
+	// ColorAuto enables color only when the writer
+	// is an *os.File that refers to a terminal.
+	ColorAuto
+)
+
+// DefaultLevelColors holds the colors used when
+// [Options.LevelColors] is nil. Each value is the parameter
+// string of an ANSI SGR sequence.
+var DefaultLevelColors = map[slog.Level]string{
+	slog.LevelDebug: "34", // blue
+	slog.LevelInfo:  "32", // green
+	slog.LevelWarn:  "33", // yellow
+	slog.LevelError: "31", // red
+}
+
+const ansiReset = "\x1b[0m"
+
+// levelColor returns the SGR parameters for the given level.
+// A level without an entry of its own uses the color of the
+// nearest level below it that has one.
+func (h *Handler) levelColor(l slog.Level) string {
+	colors := h.opts.LevelColors
+	if colors == nil {
+		colors = DefaultLevelColors
+	}
+	if c, ok := colors[l]; ok {
+		return c
+	}
+	found := false
+	var best slog.Level
+	var color string
+	for cl, c := range colors {
+		if cl <= l && (!found || cl > best) {
+			best, color, found = cl, c, true
+		}
+	}
+	return color
+}
+
+// useColor reports whether output to w should be colorized
+// in the given mode.
+func useColor(mode ColorMode, w io.Writer) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorAuto:
+		return isTerminal(w)
+	}
+	return false
+}
+
+// isTerminal reports whether w is an *os.File that
+// refers to a character device such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorFrom wraps the bytes in the buffer from start onwards
+// in an SGR sequence with the given parameters.
+func (s *handleState) colorFrom(start int, color string) {
+	if color == "" || start >= len(*s.buf) {
+		return
+	}
+	tail := len(*s.buf) - start
+	esc := len("\x1b[") + len(color) + len("m")
+	// Make room for the escape sequence and shift the tail along.
+	for i := 0; i < esc; i++ {
+		s.buf.WriteByte(0)
+	}
+	copy((*s.buf)[start+esc:], (*s.buf)[start:start+tail])
+	b := (*s.buf)[start:]
+	n := copy(b, "\x1b[")
+	n += copy(b[n:], color)
+	b[n] = 'm'
+	s.buf.WriteString(ansiReset)
+}
@@ -0,0 +1,124 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestColor(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  Options
+		level slog.Level
+		want  string
+	}{
+		{
+			name:  "never",
+			level: slog.LevelError,
+			want:  "level=ERROR msg=m a=1\n",
+		},
+		{
+			name:  "error",
+			opts:  Options{Color: ColorAlways},
+			level: slog.LevelError,
+			want:  "level=\x1b[31mERROR\x1b[0m msg=m a=1\n",
+		},
+		{
+			name:  "warn",
+			opts:  Options{Color: ColorAlways},
+			level: slog.LevelWarn,
+			want:  "level=\x1b[33mWARN\x1b[0m msg=m a=1\n",
+		},
+		{
+			name:  "custom level uses lower color",
+			opts:  Options{Color: ColorAlways},
+			level: slog.LevelWarn + 2,
+			want:  "level=\x1b[33mWARN+2\x1b[0m msg=m a=1\n",
+		},
+		{
+			name:  "below all colors",
+			opts:  Options{Color: ColorAlways},
+			level: slog.LevelDebug - 1,
+			want:  "level=DEBUG-1 msg=m a=1\n",
+		},
+		{
+			name:  "custom colors",
+			opts:  Options{Color: ColorAlways, LevelColors: map[slog.Level]string{slog.LevelInfo: "1;35"}},
+			level: slog.LevelInfo,
+			want:  "level=\x1b[1;35mINFO\x1b[0m msg=m a=1\n",
+		},
+		{
+			name:  "whole line",
+			opts:  Options{Color: ColorAlways, ColorLine: true},
+			level: slog.LevelInfo,
+			want:  "\x1b[32mlevel=INFO msg=m a=1\x1b[0m\n",
+		},
+		{
+			name:  "auto with buffer",
+			opts:  Options{Color: ColorAuto},
+			level: slog.LevelError,
+			want:  "level=ERROR msg=m a=1\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, rep := range []bool{false, true} {
+				var buf bytes.Buffer
+				opts := test.opts
+				var seen string
+				if rep {
+					opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr {
+						if a.Key == slog.LevelKey {
+							seen = a.Value.String()
+						}
+						return a
+					}
+				}
+				h := NewHandlerWithOptions(&buf, opts)
+				r := slog.NewRecord(time.Time{}, test.level, "m", 0)
+				r.AddAttrs(slog.Int("a", 1))
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				if got := buf.String(); got != test.want {
+					t.Errorf("replace %v: got %q, want %q", rep, got, test.want)
+				}
+				if rep && seen != test.level.String() {
+					t.Errorf("ReplaceAttr saw level %q, want %q", seen, test.level.String())
+				}
+			}
+		})
+	}
+}
+
+func TestColorRenamedLevel(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{ReplaceAttr: upperCaseKey},
+		Color:          ColorAlways,
+	})
+	r := slog.NewRecord(time.Time{}, slog.LevelError, "m", 0)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "LEVEL=\x1b[31mERROR\x1b[0m MSG=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestColorAutoRegularFile(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := NewHandlerWithOptions(f, Options{Color: ColorAuto})
+	if h.color {
+		t.Errorf("color enabled for regular file")
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "context"
+
+// ContextKey is the type of the context keys defined by this package.
+type ContextKey string
+
+// RequestIDContextKey is the context key under which
+// [ContextWithRequestID] stores a request ID.
+const RequestIDContextKey ContextKey = "request_id"
+
+// RequestIDKey is the key used by the handler for the request ID
+// when [Options.AddRequestID] is set.
+const RequestIDKey = "request_id"
+
+// ContextWithRequestID returns a copy of ctx that holds the given
+// request ID. HTTP middleware can use this to propagate an ID taken
+// from a header such as X-Request-ID so that the handler can log it.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDContextKey, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx
+// by [ContextWithRequestID], and whether one was found.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(RequestIDContextKey).(string)
+	return id, ok
+}
@@ -0,0 +1,160 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestRequestIDRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{ReplaceAttr: removeKeys(slog.TimeKey)},
+		AddRequestID:   true,
+	}))
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			if id := req.Header.Get("X-Request-ID"); id != "" {
+				ctx = ContextWithRequestID(ctx, id)
+			}
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+	srv := middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logger.InfoCtx(req.Context(), "hello", "a", 1)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "abc 123")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := `level=INFO msg=hello request_id="abc 123" a=1` + "\n" +
+		`level=INFO msg=hello a=1` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRequestIDFromNilContext(t *testing.T) {
+	if _, ok := RequestIDFromContext(nil); ok {
+		t.Errorf("unexpected request ID in nil context")
+	}
+	if _, ok := RequestIDFromContext(context.Background()); ok {
+		t.Errorf("unexpected request ID in empty context")
+	}
+}
+
+type traceKey struct{}
+
+func TestContextAttrs(t *testing.T) {
+	opts := Options{
+		HandlerOptions: slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == "user" {
+					a.Value = slog.StringValue(strings.ToUpper(a.Value.String()))
+				}
+				return a
+			},
+		},
+		ContextAttrs: []func(context.Context) []slog.Attr{
+			func(ctx context.Context) []slog.Attr {
+				if id, ok := ctx.Value(traceKey{}).(string); ok {
+					return []slog.Attr{slog.String("trace", id)}
+				}
+				return nil
+			},
+			func(ctx context.Context) []slog.Attr {
+				return []slog.Attr{slog.String("user", "bob"), slog.Int("n", 1)}
+			},
+		},
+	}
+	ctx := context.WithValue(context.Background(), traceKey{}, "t1")
+	for _, test := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"with values", ctx, `level=INFO msg=m pre=1 g.trace=t1 g.user=BOB g.n=1 g.a=2`},
+		{"without trace", context.Background(), `level=INFO msg=m pre=1 g.user=BOB g.n=1 g.a=2`},
+		{"nil context", nil, `level=INFO msg=m pre=1 g.a=2`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, opts)
+			h = h.WithAttrs([]slog.Attr{slog.Int("pre", 1)}).WithGroup("g")
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Int("a", 2))
+			if err := h.Handle(test.ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+type debugKey struct{}
+
+func TestLevelFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{Level: slog.LevelWarn},
+		LevelFromContext: func(ctx context.Context) (slog.Level, bool) {
+			if ctx.Value(debugKey{}) != nil {
+				return slog.LevelDebug, true
+			}
+			return 0, false
+		},
+	})
+	logger := slog.New(h)
+	debugCtx := context.WithValue(context.Background(), debugKey{}, true)
+	for _, test := range []struct {
+		ctx   context.Context
+		level slog.Level
+		want  bool
+	}{
+		{context.Background(), slog.LevelDebug, false},
+		{context.Background(), slog.LevelInfo, false},
+		{context.Background(), slog.LevelWarn, true},
+		{debugCtx, slog.LevelDebug, true},
+		{debugCtx, slog.LevelInfo, true},
+		{nil, slog.LevelInfo, false},
+		{nil, slog.LevelError, true},
+	} {
+		if got := h.Enabled(test.ctx, test.level); got != test.want {
+			t.Errorf("Enabled(%v, %v) = %t, want %t", test.ctx != nil && test.ctx.Value(debugKey{}) != nil, test.level, got, test.want)
+		}
+	}
+	logger.DebugCtx(context.Background(), "hidden")
+	logger.DebugCtx(debugCtx, "shown")
+	// Handle applies the context's level too.
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "dropped", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(debugCtx, slog.NewRecord(time.Time{}, slog.LevelInfo, "kept", 0)); err != nil {
+		t.Fatal(err)
+	}
+	got := removeTimes(buf.String())
+	if want := "level=DEBUG msg=shown\nlevel=INFO msg=kept\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// removeTimes removes the leading time from each line.
+func removeTimes(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "time=") {
+			_, lines[i], _ = strings.Cut(line, " ")
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// DedupKey is the key of the attribute added by [Options.AddDedupHash].
+const DedupKey = "_dedup"
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv64 is a 64-bit FNV-1a hash.
+type fnv64 uint64
+
+// add adds s to the hash, followed by a zero terminator
+// so that the boundaries between the parts are significant.
+func (h *fnv64) add(s string) {
+	for i := 0; i < len(s); i++ {
+		*h ^= fnv64(s[i])
+		*h *= fnvPrime64
+	}
+	*h *= fnvPrime64 // the zero terminator
+}
+
+// addUint64 adds the bytes of x to the hash.
+func (h *fnv64) addUint64(x uint64) {
+	for shift := 0; shift < 64; shift += 8 {
+		*h ^= fnv64(x >> uint(shift) & 0xff)
+		*h *= fnvPrime64
+	}
+}
+
+// hashValue returns the hash of v's kind and content, which
+// does not depend on the options used to format it. Values of
+// kind KindAny other than errors are hashed in their canonical
+// JSON encoding, which, unlike their fmt form, does not include
+// the addresses of any pointers inside them.
+func hashValue(v slog.Value) uint64 {
+	h := fnv64(fnvOffset64)
+	h.add(v.Kind().String())
+	if v.Kind() != slog.KindAny {
+		h.add(v.String())
+		return uint64(h)
+	}
+	if err, ok := v.Any().(error); ok {
+		h.add(err.Error())
+		return uint64(h)
+	}
+	e := newJSONEncoder()
+	defer e.free()
+	data, err := e.appendCanonical(v.Any(), nil)
+	if err != nil {
+		// The error describes the value without
+		// depending on its address.
+		h.add("!" + err.Error())
+	} else {
+		h.add(string(data))
+	}
+	return uint64(h)
+}
+
+// setFieldHashes sets the hash of the fields collected
+// from index i onwards, which were all written for the value v.
+func (s *handleState) setFieldHashes(i int, v slog.Value) {
+	if !s.collectFields || !s.h.opts.AddDedupHash || i == len(s.fields) {
+		return
+	}
+	vh := hashValue(v)
+	for ; i < len(s.fields); i++ {
+		s.fields[i].hash = vh
+	}
+}
+
+// hashContent returns a 64-bit FNV-1a hash of the level and
+// message followed by the keys and values of the collected fields,
+// sorted by key. The other built-in attributes, such as the time
+// and sequence number, are left out because they differ between
+// otherwise identical records. Only the values themselves are
+// hashed, not their formatted form, so that the hash does not depend
+// on options such as DittoRepeatedAttrs, Color or QuoteChar.
+func (s *handleState) hashContent(level slog.Level, msg string) uint64 {
+	h := fnv64(fnvOffset64)
+	h.add(strconv.Itoa(int(level)))
+	h.add(msg)
+	fields := slices.Clone(s.fields)
+	slices.SortFunc(fields, func(a, b field) int {
+		if c := strings.Compare(a.key, b.key); c != 0 {
+			return c
+		}
+		// Attributes with the same key are ordered by value,
+		// so that their order does not matter either.
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return 0
+	})
+	for _, f := range fields {
+		h.add(f.key)
+		h.addUint64(f.hash)
+	}
+	return uint64(h)
+}
+
+// appendDedupHash appends the attribute holding the hash
+// computed by hashContent.
+func (s *handleState) appendDedupHash() {
+	s.appendKey(DedupKey)
+	start := len(*s.buf)
+	*s.buf = appendHex64(*s.buf, s.dedupHash)
+	s.quoteAllFrom(start)
+}
+
+// appendHex64 appends x to dst as 16 lower-case hexadecimal digits.
+func appendHex64(dst []byte, x uint64) []byte {
+	const digits = "0123456789abcdef"
+	for shift := 60; shift >= 0; shift -= 4 {
+		dst = append(dst, digits[x>>uint(shift)&0xf])
+	}
+	return dst
+}
@@ -0,0 +1,220 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+var dedupRE = regexp.MustCompile(`^(.*) _dedup=([0-9a-f]{16})\n$`)
+
+func TestAddDedupHash(t *testing.T) {
+	format := func(t *testing.T, tm time.Time, with func(slog.Handler) slog.Handler, msg string, attrs ...slog.Attr) (string, string) {
+		var buf bytes.Buffer
+		var h slog.Handler = NewHandlerWithOptions(&buf, Options{AddDedupHash: true})
+		if with != nil {
+			h = with(h)
+		}
+		r := slog.NewRecord(tm, slog.LevelInfo, msg, 0)
+		r.AddAttrs(attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		m := dedupRE.FindStringSubmatch(buf.String())
+		if m == nil {
+			t.Fatalf("no dedup hash in %q", buf.String())
+		}
+		return m[1], m[2]
+	}
+	attrs := []slog.Attr{slog.Int("a", 1), slog.String("b", "x")}
+	line, hash := format(t, testTime, nil, "m", attrs...)
+	if want := `time=2000-01-02T03:04:05.000Z level=INFO msg=m a=1 b=x`; line != want {
+		t.Errorf("got line %q, want %q", line, want)
+	}
+
+	if _, h := format(t, testTime.Add(time.Hour), nil, "m", attrs...); h != hash {
+		t.Errorf("hash changed with time: %s vs %s", h, hash)
+	}
+	if _, h := format(t, time.Time{}, nil, "m", attrs...); h != hash {
+		t.Errorf("hash changed without time: %s vs %s", h, hash)
+	}
+	if _, h := format(t, testTime, nil, "m", attrs[1], attrs[0]); h != hash {
+		t.Errorf("hash changed with attribute order: %s vs %s", h, hash)
+	}
+	withA := func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs[:1]) }
+	if _, h := format(t, testTime, withA, "m", attrs[1]); h != hash {
+		t.Errorf("hash changed with WithAttrs: %s vs %s", h, hash)
+	}
+	if _, h := format(t, testTime, nil, "n", attrs...); h == hash {
+		t.Errorf("hash unchanged with different message")
+	}
+	if _, h := format(t, testTime, nil, "m", slog.Int("a", 2), attrs[1]); h == hash {
+		t.Errorf("hash unchanged with different attribute")
+	}
+	// The hash must not depend on where one attribute ends
+	// and the next begins.
+	_, h1 := format(t, testTime, nil, "m", slog.String("a", "x b=y"))
+	_, h2 := format(t, testTime, nil, "m", slog.String("a", "x"), slog.String("b", "y"))
+	if h1 == h2 {
+		t.Errorf("hash ignores field boundaries")
+	}
+}
+
+func TestAddDedupHashMaxRecordLen(t *testing.T) {
+	format := func(max int) string {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{AddDedupHash: true, MaxRecordLen: max})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.String("a", "one"), slog.String("s", strings.Repeat("x", 40)))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	full := format(0)
+	m := dedupRE.FindStringSubmatch(full)
+	if m == nil {
+		t.Fatalf("no dedup hash in %q", full)
+	}
+	keep := `level=INFO msg=m a=one … (truncated) _dedup=` + m[2]
+	drop := `level=INFO msg=m … (truncated) _dedup=` + m[2]
+	for _, test := range []struct {
+		max  int
+		want string
+	}{
+		{len(full) - 2, keep},
+		{len(keep), keep},
+		{len(keep) - 1, drop},
+	} {
+		got := format(test.max)
+		if want := test.want + "\n"; got != want {
+			t.Errorf("max %d: got %q, want %q", test.max, got, want)
+		}
+		if n := len(got) - 1; n > test.max {
+			t.Errorf("max %d: got line of length %d", test.max, n)
+		}
+	}
+}
+
+func TestAddDedupHashIgnoresPerRecordBuiltins(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		AddDedupHash: true,
+		Sequence:     true,
+		AddMonotonic: true,
+		IncludePID:   true,
+	})
+	var hashes []string
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("a", 1))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		m := dedupRE.FindStringSubmatch(buf.String())
+		if m == nil {
+			t.Fatalf("no dedup hash in %q", buf.String())
+		}
+		if want := " seq=" + strconv.Itoa(i+1) + " "; !strings.Contains(m[1], want) {
+			t.Errorf("record %d: no %q in %q", i, want, m[1])
+		}
+		hashes = append(hashes, m[2])
+	}
+	if hashes[0] != hashes[1] {
+		t.Errorf("hashes differ: %s vs %s", hashes[0], hashes[1])
+	}
+	// The hash is the same as without the extra built-ins.
+	buf.Reset()
+	h = NewHandlerWithOptions(&buf, Options{AddDedupHash: true})
+	r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("a", 1))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if m := dedupRE.FindStringSubmatch(buf.String()); m == nil || m[2] != hashes[0] {
+		t.Errorf("got %q, want hash %s", buf.String(), hashes[0])
+	}
+}
+
+var dedupAttrRE = regexp.MustCompile(`_dedup=([0-9a-f]{16})`)
+
+func TestAddDedupHashIgnoresFormatting(t *testing.T) {
+	hashes := func(opts Options, n int) []string {
+		var buf bytes.Buffer
+		opts.AddDedupHash = true
+		h := NewHandlerWithOptions(&buf, opts).WithAttrs([]slog.Attr{slog.String("w", "x y")})
+		for i := 0; i < n; i++ {
+			r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Int("a", 1), slog.String("b", "x y"))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var hs []string
+		for _, line := range strings.SplitAfter(buf.String(), "\n") {
+			if line == "" {
+				continue
+			}
+			m := dedupAttrRE.FindStringSubmatch(line)
+			if m == nil {
+				t.Fatalf("no dedup hash in %q", line)
+			}
+			hs = append(hs, m[1])
+		}
+		return hs
+	}
+	want := hashes(Options{}, 1)[0]
+	for _, test := range []struct {
+		name string
+		opts Options
+	}{
+		{"ditto", Options{DittoRepeatedAttrs: []string{"a", "b"}}},
+		{"color", Options{Color: ColorAlways}},
+		{"color-line", Options{Color: ColorAlways, ColorLine: true}},
+		{"quote-char", Options{QuoteChar: '\''}},
+		{"ditto-color", Options{DittoRepeatedAttrs: []string{"a"}, Color: ColorAlways}},
+	} {
+		for i, got := range hashes(test.opts, 2) {
+			if got != want {
+				t.Errorf("%s: record %d: got hash %s, want %s", test.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestAddDedupHashPointers(t *testing.T) {
+	type inner struct{ N int }
+	type outer struct {
+		P *inner
+	}
+	hash := func(v outer) string {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{AddDedupHash: true})
+		r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Any("v", v))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		m := dedupAttrRE.FindStringSubmatch(buf.String())
+		if m == nil {
+			t.Fatalf("no dedup hash in %q", buf.String())
+		}
+		return m[1]
+	}
+	// Separately allocated values with the same content.
+	h1 := hash(outer{P: &inner{1}})
+	h2 := hash(outer{P: &inner{1}})
+	if h1 != h2 {
+		t.Errorf("hash depends on pointer address: %s vs %s", h1, h2)
+	}
+	if h3 := hash(outer{P: &inner{2}}); h3 == hash(outer{P: &inner{1}}) {
+		t.Errorf("hash unchanged with different pointed-to value")
+	}
+}
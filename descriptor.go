@@ -0,0 +1,164 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// descriptorPrefix starts the format descriptor line.
+const descriptorPrefix = "# slogtext"
+
+// descriptorVersion is the version of the descriptor grammar.
+const descriptorVersion = 1
+
+var (
+	layoutNames         = []string{"text", "prototext", "xml"}
+	bytesEncodingNames  = []string{"quoted", "base64", "hex"}
+	durationFormatNames = []string{"string", "seconds", "millis", "nanos", "floatmillis"}
+	timeFormatNames     = []string{"rfc3339millis", "unixseconds", "unixmillis", "unixnanos"}
+)
+
+// descriptor returns the format descriptor line for the options,
+// or the empty string if they all have their default values.
+func (opts *Options) descriptor() string {
+	var b strings.Builder
+	word := func(name, val string) {
+		b.WriteString(" " + name + "=" + val)
+	}
+	quoted := func(name, val string) {
+		word(name, strconv.Quote(val))
+	}
+	if opts.Layout != LayoutText {
+		word("layout", layoutNames[opts.Layout])
+	}
+	if opts.KVSeparator != "" {
+		quoted("kvsep", opts.KVSeparator)
+	}
+	if opts.FieldSeparator != "" {
+		quoted("fieldsep", opts.FieldSeparator)
+	}
+	if opts.GroupSeparator != "" {
+		quoted("groupsep", opts.GroupSeparator)
+	}
+	if opts.QuoteChar != 0 {
+		quoted("quote", string(rune(opts.QuoteChar)))
+	}
+	switch {
+	case opts.LineTerminator != "":
+		quoted("eol", opts.LineTerminator)
+	case opts.NoNewline:
+		quoted("eol", "")
+	}
+	switch {
+	case opts.AppendTime != nil:
+		word("time", "custom")
+	case opts.TimeLayout != "":
+		quoted("time", opts.TimeLayout)
+	case opts.TimeFormat != TimeRFC3339Millis:
+		word("time", timeFormatNames[opts.TimeFormat])
+	}
+	if opts.DurationFormat != DurationString {
+		word("duration", durationFormatNames[opts.DurationFormat])
+	}
+	if opts.BytesEncoding != BytesQuoted {
+		word("bytes", bytesEncodingNames[opts.BytesEncoding])
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return descriptorPrefix + " v=" + strconv.Itoa(descriptorVersion) + b.String() + "\n"
+}
+
+// ParseDescriptor parses a format descriptor line written by a handler
+// with the SelfDescribing option, with or without its final newline.
+// It returns options with the described fields set, so that
+// a reader of the following lines knows how they are formatted.
+// Names that it does not recognize are ignored. When the time
+// is described as custom, TimeLayout is left empty, because
+// the Go function that formatted the times cannot be described.
+func ParseDescriptor(line string) (Options, error) {
+	var opts Options
+	rest, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), descriptorPrefix+" ")
+	if !ok {
+		return opts, fmt.Errorf("not a format descriptor")
+	}
+	seenVersion := false
+	for rest != "" {
+		name, val, ok := strings.Cut(rest, "=")
+		if !ok || name == "" || strings.ContainsRune(name, ' ') {
+			return opts, fmt.Errorf("invalid format descriptor item %q", rest)
+		}
+		rest = val
+		quoted := strings.HasPrefix(val, `"`)
+		if quoted {
+			q, err := strconv.QuotedPrefix(val)
+			if err != nil {
+				return opts, fmt.Errorf("invalid value for %s in format descriptor", name)
+			}
+			val, _ = strconv.Unquote(q)
+			rest = rest[len(q):]
+			if rest != "" {
+				if rest, ok = strings.CutPrefix(rest, " "); !ok {
+					return opts, fmt.Errorf("missing space after %s in format descriptor", name)
+				}
+			}
+		} else {
+			val, rest, _ = strings.Cut(val, " ")
+		}
+		var err error
+		switch name {
+		case "v":
+			if val != strconv.Itoa(descriptorVersion) {
+				return opts, fmt.Errorf("unsupported format descriptor version %q", val)
+			}
+			seenVersion = true
+		case "layout":
+			opts.Layout, err = parseName[Layout](name, val, layoutNames)
+		case "kvsep":
+			opts.KVSeparator = val
+		case "fieldsep":
+			opts.FieldSeparator = val
+		case "groupsep":
+			opts.GroupSeparator = val
+		case "quote":
+			if len(val) != 1 {
+				return opts, fmt.Errorf("invalid quote %q in format descriptor", val)
+			}
+			opts.QuoteChar = val[0]
+		case "eol":
+			opts.LineTerminator = val
+			opts.NoNewline = val == ""
+		case "time":
+			if quoted {
+				opts.TimeLayout = val
+			} else if val != "custom" {
+				opts.TimeFormat, err = parseName[TimeFormat](name, val, timeFormatNames)
+			}
+		case "duration":
+			opts.DurationFormat, err = parseName[DurationFormat](name, val, durationFormatNames)
+		case "bytes":
+			opts.BytesEncoding, err = parseName[BytesEncoding](name, val, bytesEncodingNames)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	if !seenVersion {
+		return opts, fmt.Errorf("format descriptor has no version")
+	}
+	return opts, nil
+}
+
+func parseName[T ~int](what, val string, names []string) (T, error) {
+	for i, name := range names {
+		if name == val {
+			return T(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q in format descriptor", what, val)
+}
@@ -0,0 +1,131 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestSelfDescribing(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{{
+		name: "default",
+		want: "",
+	}, {
+		name: "separators",
+		opts: Options{KVSeparator: ":", FieldSeparator: "\t", GroupSeparator: "/", QuoteChar: '\''},
+		want: `# slogtext v=1 kvsep=":" fieldsep="\t" groupsep="/" quote="'"` + "\n",
+	}, {
+		name: "encodings",
+		opts: Options{
+			TimeLayout:     time.Kitchen,
+			DurationFormat: DurationFloatMillis,
+			BytesEncoding:  BytesBase64,
+			LineTerminator: "\r\n",
+		},
+		want: `# slogtext v=1 eol="\r\n" time="3:04PM" duration=floatmillis bytes=base64` + "\n",
+	}, {
+		name: "layout",
+		opts: Options{Layout: LayoutXML, NoNewline: true},
+		want: `# slogtext v=1 layout=xml eol=""` + "\n",
+	}, {
+		name: "unix-time",
+		opts: Options{TimeFormat: TimeUnixMillis},
+		want: `# slogtext v=1 time=unixmillis` + "\n",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := test.opts
+			opts.SelfDescribing = true
+			h := NewHandlerWithOptions(&buf, opts)
+			for i := 0; i < 2; i++ {
+				if err := h.WithGroup("g").Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := buf.String()
+			if !strings.HasPrefix(got, test.want) {
+				t.Fatalf("got %q, want prefix %q", got, test.want)
+			}
+			if test.want == "" {
+				if strings.HasPrefix(got, "#") {
+					t.Fatalf("unexpected descriptor in %q", got)
+				}
+				return
+			}
+			if n := strings.Count(got, descriptorPrefix); n != 1 {
+				t.Errorf("got %d descriptors, want 1", n)
+			}
+			// A reader configured from the descriptor sees the
+			// same format options as the writer.
+			parsed, err := ParseDescriptor(test.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := formatOptions(test.opts); !reflect.DeepEqual(formatOptions(parsed), want) {
+				t.Errorf("got options %+v, want %+v", formatOptions(parsed), want)
+			}
+			// A handler with a new writer writes the descriptor again.
+			var buf2 bytes.Buffer
+			if err := h.WithWriter(&buf2).Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(buf2.String(), test.want) {
+				t.Errorf("new writer: got %q, want prefix %q", buf2.String(), test.want)
+			}
+		})
+	}
+}
+
+// formatOptions returns the options described by a format descriptor.
+func formatOptions(opts Options) Options {
+	return Options{
+		Layout:         opts.Layout,
+		KVSeparator:    opts.KVSeparator,
+		FieldSeparator: opts.FieldSeparator,
+		GroupSeparator: opts.GroupSeparator,
+		QuoteChar:      opts.QuoteChar,
+		LineTerminator: opts.LineTerminator,
+		NoNewline:      opts.NoNewline,
+		TimeLayout:     opts.TimeLayout,
+		TimeFormat:     opts.TimeFormat,
+		DurationFormat: opts.DurationFormat,
+		BytesEncoding:  opts.BytesEncoding,
+	}
+}
+
+func TestParseDescriptorErrors(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		want string
+	}{
+		{`level=INFO msg=m`, "not a format descriptor"},
+		{`# slogtext kvsep=":"`, "format descriptor has no version"},
+		{`# slogtext v=2`, `unsupported format descriptor version "2"`},
+		{`# slogtext v=1 layout=json`, `unknown layout "json" in format descriptor`},
+		{`# slogtext v=1 kvsep=":`, "invalid value for kvsep in format descriptor"},
+		{`# slogtext v=1 kvsep=":"x`, "missing space after kvsep in format descriptor"},
+		{`# slogtext v=1 quote="ab"`, `invalid quote "ab" in format descriptor`},
+	} {
+		_, err := ParseDescriptor(test.line)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("ParseDescriptor(%q): got error %v, want %q", test.line, err, test.want)
+		}
+	}
+	// Unknown names are ignored.
+	opts, err := ParseDescriptor(`# slogtext v=1 future="x" kvsep=":"` + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.KVSeparator != ":" {
+		t.Errorf("got KVSeparator %q, want %q", opts.KVSeparator, ":")
+	}
+}
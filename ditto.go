@@ -0,0 +1,60 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// DittoMarker is written in place of a value that is the same
+// as in the previous record when [Options.DittoRepeatedAttrs] is used.
+const DittoMarker = `"`
+
+// dittoState remembers the most recent value written
+// for each key eligible for dittoing.
+type dittoState struct {
+	mu   sync.Mutex
+	keys map[string]bool
+	last map[string][]byte // keyed by fully qualified key
+}
+
+func newDittoState(keys []string) *dittoState {
+	if len(keys) == 0 {
+		return nil
+	}
+	d := &dittoState{
+		keys: make(map[string]bool),
+		last: make(map[string][]byte),
+	}
+	for _, k := range keys {
+		d.keys[k] = true
+	}
+	return d
+}
+
+// appendDittoValue appends v to the buffer, or DittoMarker if v
+// formats the same as the last value written for the key.
+func (s *handleState) appendDittoValue(key string, v slog.Value) {
+	start := len(*s.buf)
+	s.appendKeyedValue(key, v)
+	val := (*s.buf)[start:]
+	fullKey := key
+	if s.prefix != nil && len(*s.prefix) > 0 {
+		fullKey = string(*s.prefix) + key
+	}
+	d := s.h.ditto
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if prev, ok := d.last[fullKey]; ok && bytes.Equal(prev, val) {
+		*s.buf = (*s.buf)[:start]
+		s.buf.WriteString(DittoMarker)
+		return
+	}
+	d.last[fullKey] = slices.Clone(val)
+}
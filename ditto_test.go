@@ -0,0 +1,41 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestDittoRepeatedAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	var h slog.Handler = NewHandlerWithOptions(&buf, Options{
+		DittoRepeatedAttrs: []string{"trace_id"},
+	})
+	ctx := context.Background()
+	logf := func(h slog.Handler, msg string, attrs ...slog.Attr) {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, msg, 0)
+		r.AddAttrs(attrs...)
+		if err := h.Handle(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logf(h, "a", slog.String("trace_id", "abc"), slog.Int("n", 1))
+	logf(h, "b", slog.String("trace_id", "abc"), slog.Int("n", 1))
+	logf(h.WithAttrs(nil), "c", slog.String("trace_id", "abc"))
+	logf(h, "d", slog.String("trace_id", "def"))
+	logf(h.WithGroup("g"), "e", slog.String("trace_id", "def"))
+	logf(h.WithGroup("g"), "f", slog.String("trace_id", "def"))
+	want := `level=INFO msg=a trace_id=abc n=1
+level=INFO msg=b trace_id=" n=1
+level=INFO msg=c trace_id="
+level=INFO msg=d trace_id=def
+level=INFO msg=e g.trace_id=def
+level=INFO msg=f g.trace_id="
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
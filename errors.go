@@ -0,0 +1,96 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// stackTracer is implemented by errors that record the
+// program counters of the stack where they were created.
+type stackTracer interface {
+	StackTrace() []uintptr
+}
+
+// appendErrorValue appends the text for an error value,
+// as determined by the ErrorWithType and ErrorChain options.
+func (s *handleState) appendErrorValue(err error) {
+	if !s.h.opts.ErrorChain {
+		msg := err.Error()
+		if s.h.opts.ErrorWithType {
+			msg = withErrorType(msg, err)
+		}
+		s.appendString(msg)
+		return
+	}
+	var b strings.Builder
+	for e := err; e != nil; {
+		inner := errors.Unwrap(e)
+		msg := e.Error()
+		if inner != nil {
+			// Most wrapping errors already include the message
+			// of the error they wrap.
+			msg = strings.TrimSuffix(msg, ": "+inner.Error())
+		}
+		if s.h.opts.ErrorWithType {
+			msg = withErrorType(msg, e)
+		}
+		if b.Len() > 0 {
+			b.WriteString(": ")
+		}
+		b.WriteString(msg)
+		e = inner
+	}
+	s.appendString(b.String())
+}
+
+// withErrorType returns msg followed by the Go type of err
+// in parentheses.
+func withErrorType(msg string, err error) string {
+	return fmt.Sprintf("%s (%T)", msg, err)
+}
+
+// appendStackTrace appends an attribute holding the stack trace of v,
+// if it is an error with a stack trace as determined by the
+// ErrorStackTrace option. The attribute's key is "stack"
+// in a group named by key.
+func (s *handleState) appendStackTrace(key string, v slog.Value) {
+	if v.Kind() != slog.KindAny {
+		return
+	}
+	err, ok := v.Any().(error)
+	if !ok {
+		return
+	}
+	var st stackTracer
+	if !errors.As(err, &st) {
+		return
+	}
+	pcs := st.StackTrace()
+	if len(pcs) == 0 {
+		return
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(sourceString(shortFunction(f.Function), trimSourcePath(f.File, s.h.opts.SourceMode), f.Line))
+		if !more {
+			break
+		}
+	}
+	s.openGroup(key)
+	s.appendKey("stack")
+	s.appendString(b.String())
+	s.closeGroup(key)
+}
@@ -0,0 +1,123 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// opaqueError wraps an error without including its message.
+type opaqueError struct {
+	msg string
+	err error
+}
+
+func (e *opaqueError) Error() string { return e.msg }
+func (e *opaqueError) Unwrap() error { return e.err }
+
+// textError is an error that is also a TextMarshaler.
+type textError struct{}
+
+func (textError) Error() string                { return "from Error" }
+func (textError) MarshalText() ([]byte, error) { return []byte("from MarshalText"), nil }
+
+func TestErrorValues(t *testing.T) {
+	base := errors.New("c")
+	wrapped := fmt.Errorf("a: %w", fmt.Errorf("b: %w", base))
+	opaque := &opaqueError{"a", &opaqueError{"b", base}}
+	for _, test := range []struct {
+		name string
+		opts Options
+		err  error
+		want string
+	}{
+		{"simple", Options{}, base, `err=c`},
+		{"wrapped", Options{}, wrapped, `err="a: b: c"`},
+		{"opaque", Options{}, opaque, `err=a`},
+		{"text marshaler", Options{}, textError{}, `err="from MarshalText"`},
+		{"text marshaler with type", Options{ErrorWithType: true}, textError{}, `err="from MarshalText"`},
+		{"type", Options{ErrorWithType: true}, base, `err="c (*errors.errorString)"`},
+		{"chain", Options{ErrorChain: true}, wrapped, `err="a: b: c"`},
+		{"chain opaque", Options{ErrorChain: true}, opaque, `err="a: b: c"`},
+		{"chain with type", Options{ErrorChain: true, ErrorWithType: true}, wrapped, `err="a (*fmt.wrapError): b (*fmt.wrapError): c (*errors.errorString)"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Any("err", test.err))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// stackError is an error that records the stack where it was created.
+type stackError struct {
+	pcs []uintptr
+}
+
+func newStackError() error {
+	pcs := make([]uintptr, 2)
+	n := runtime.Callers(1, pcs)
+	return &stackError{pcs[:n]}
+}
+
+func (e *stackError) Error() string         { return "failed" }
+func (e *stackError) StackTrace() []uintptr { return e.pcs }
+
+func TestErrorStackTrace(t *testing.T) {
+	err := newStackError()
+	for _, test := range []struct {
+		name string
+		opts Options
+		err  error
+		want string
+	}{{
+		name: "without option",
+		opts: Options{SourceMode: SourceShort},
+		err:  err,
+		want: `^level=INFO msg=m err=failed n=1$`,
+	}, {
+		name: "short",
+		opts: Options{ErrorStackTrace: true, SourceMode: SourceShort},
+		err:  err,
+		want: `^level=INFO msg=m err=failed err.stack="slogtext.newStackError@errors_test.go:\d+, slogtext.TestErrorStackTrace@errors_test.go:\d+" n=1$`,
+	}, {
+		name: "wrapped",
+		opts: Options{ErrorStackTrace: true, SourceMode: SourcePackage},
+		err:  fmt.Errorf("wrapped: %w", err),
+		want: `^level=INFO msg=m err="wrapped: failed" err.stack="slogtext.newStackError@[^/]+/errors_test.go:\d+, slogtext.TestErrorStackTrace@[^/]+/errors_test.go:\d+" n=1$`,
+	}, {
+		name: "no stack",
+		opts: Options{ErrorStackTrace: true},
+		err:  errors.New("failed"),
+		want: `^level=INFO msg=m err=failed n=1$`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Any("err", test.err), slog.Int("n", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if !regexp.MustCompile(test.want).MatchString(got) {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// appendExploded appends r to dst as described by [Options.ExplodeKey]:
+// one line for each element of the exploded attribute, or a single
+// line if there is no such attribute or its value is not a non-empty slice.
+func (h *Handler) appendExploded(dst *buffer, ctx context.Context, r slog.Record, rv recordValues) {
+	key := h.opts.ExplodeKey
+	var elems reflect.Value
+	r.Attrs(func(a slog.Attr) {
+		if a.Key != key || a.Value.Kind() != slog.KindAny {
+			return
+		}
+		if _, ok := byteSlice(a.Value.Any()); ok {
+			return
+		}
+		v := reflect.ValueOf(a.Value.Any())
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			elems = v
+		}
+	})
+	if !elems.IsValid() || elems.Len() == 0 {
+		h.appendRecord(dst, ctx, r, rv)
+		return
+	}
+	elemKey := singular(key)
+	for i := 0; i < elems.Len(); i++ {
+		if i > 0 && rv.seq != 0 {
+			// Each line has its own sequence number.
+			rv.seq = h.seq.Add(1)
+		}
+		r1 := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+		r.Attrs(func(a slog.Attr) {
+			if a.Key == key {
+				a = slog.Any(elemKey, elems.Index(i).Interface())
+			}
+			r1.AddAttrs(a)
+		})
+		h.appendRecord(dst, ctx, r1, rv)
+	}
+}
+
+// singular returns a singular form of the plural key,
+// by removing a trailing "s". It returns other keys unchanged.
+func singular(key string) string {
+	if len(key) > 1 && !strings.HasSuffix(key, "ss") {
+		return strings.TrimSuffix(key, "s")
+	}
+	return key
+}
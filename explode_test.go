@@ -0,0 +1,78 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestExplodeKey(t *testing.T) {
+	type item struct {
+		ID int
+	}
+	for _, test := range []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{
+			name:  "three elements",
+			value: []item{{1}, {2}, {3}},
+			want: `level=INFO msg=audit user=bob item={"ID":1} n=1` + "\n" +
+				`level=INFO msg=audit user=bob item={"ID":2} n=1` + "\n" +
+				`level=INFO msg=audit user=bob item={"ID":3} n=1` + "\n",
+		},
+		{
+			name:  "array",
+			value: [2]string{"a", "b c"},
+			want: `level=INFO msg=audit user=bob item=a n=1` + "\n" +
+				`level=INFO msg=audit user=bob item="b c" n=1` + "\n",
+		},
+		{
+			name:  "empty slice",
+			value: []int{},
+			want:  `level=INFO msg=audit user=bob items=[] n=1` + "\n",
+		},
+		{
+			name:  "not a slice",
+			value: 42,
+			want:  `level=INFO msg=audit user=bob items=42 n=1` + "\n",
+		},
+		{
+			name:  "bytes",
+			value: []byte("ab"),
+			want:  `level=INFO msg=audit user=bob items="ab" n=1` + "\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &countingWriter{w: &buf}
+			h := NewHandlerWithOptions(w, Options{ExplodeKey: "items"})
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "audit", 0)
+			r.AddAttrs(slog.String("user", "bob"), slog.Any("items", test.value), slog.Int("n", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+			if w.n != 1 {
+				t.Errorf("got %d writes, want 1", w.n)
+			}
+		})
+	}
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	w *bytes.Buffer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return w.w.Write(p)
+}
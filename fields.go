@@ -0,0 +1,244 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// field records the position of an attribute in a buffer.
+type field struct {
+	key        string // fully qualified key
+	prefixLen  int    // length of the group prefix in key
+	start      int    // offset of the key in the buffer
+	valueStart int    // offset of the value in the buffer
+	bare       bool   // whether the key has no value, as for BareBoolFlags
+	negated    bool   // whether a bare key is preceded by "!"
+	hash       uint64 // hash of the value, for AddDedupHash
+}
+
+// fieldSpan holds a field together with the offset of its end.
+type fieldSpan struct {
+	field
+	end int
+}
+
+// rewritesFields reports whether the options require the attributes
+// to be rearranged after they have been formatted.
+func (opts *Options) rewritesFields() bool {
+	return opts.SortKeys || opts.AbbreviateGroupPrefixes || len(opts.Schema) > 0
+}
+
+// collectsFields reports whether the position of each
+// attribute needs to be recorded as it is formatted.
+func (opts *Options) collectsFields() bool {
+	return opts.rewritesFields() || opts.AddDedupHash || opts.MaxRecordLen > 0
+}
+
+// markField records in s.fields that an attribute with the given
+// key starts at the end of the buffer. It is used when rewriting
+// the attributes, after which the fields hold only their keys and
+// where they start, for truncateRecordFrom.
+func (s *handleState) markField(key string) {
+	s.fields = append(s.fields, field{key: key, start: len(*s.buf)})
+}
+
+// rewriteFields rewrites the attributes in the buffer from start
+// onwards, sorting them if SortKeys is set and abbreviating their
+// group prefixes if AbbreviateGroupPrefixes is set, or arranging
+// them according to the Schema if there is one.
+func (s *handleState) rewriteFields(start int) {
+	if len(s.h.opts.Schema) > 0 {
+		s.rewriteSchema(start)
+		return
+	}
+	if len(s.fields) == 0 {
+		return
+	}
+	spans := s.fieldSpans(start)
+	if s.h.opts.SortKeys {
+		sortSpans(spans)
+	}
+	var codes map[string]int
+	var legend []string
+	if s.h.opts.AbbreviateGroupPrefixes {
+		codes, legend = groupPrefixCodes(spans)
+	}
+	region := newBuffer()
+	defer region.Free()
+	region.Write((*s.buf)[start:])
+	*s.buf = (*s.buf)[:start]
+	s.fields = s.fields[:0]
+	for i, prefix := range legend {
+		if len(*s.buf) > 0 {
+			s.appendFieldSep()
+		}
+		s.markField(abbrevCode(i + 1))
+		s.buf.WriteString(abbrevCode(i + 1))
+		s.appendKVSep()
+		s.appendString(strings.TrimSuffix(prefix, s.groupSep()))
+	}
+	for _, sp := range spans {
+		if len(*s.buf) > 0 {
+			s.appendFieldSep()
+		}
+		s.markField(sp.key)
+		if code, ok := codes[sp.key[:sp.prefixLen]]; ok {
+			mark := ""
+			if sp.negated {
+				mark = "!"
+			}
+			s.appendKeyString(mark + abbrevCode(code) + s.groupSep() + sp.key[sp.prefixLen:])
+			if !sp.bare {
+				s.appendKVSep()
+			}
+			s.buf.Write((*region)[sp.valueStart:sp.end])
+		} else {
+			s.buf.Write((*region)[sp.start:sp.end])
+		}
+	}
+}
+
+// fieldSpans returns the spans of the collected fields, with
+// positions relative to start.
+func (s *handleState) fieldSpans(start int) []fieldSpan {
+	sepLen := 1
+	if sep := s.h.opts.FieldSeparator; sep != "" {
+		sepLen = len(sep)
+	}
+	spans := make([]fieldSpan, len(s.fields))
+	for i, f := range s.fields {
+		end := len(*s.buf)
+		if i+1 < len(s.fields) {
+			end = s.fields[i+1].start - sepLen
+		}
+		f.start -= start
+		f.valueStart -= start
+		spans[i] = fieldSpan{f, end - start}
+	}
+	return spans
+}
+
+// sortSpans sorts spans by key. Attributes with the same key
+// remain in their original order.
+func sortSpans(spans []fieldSpan) {
+	slices.SortStableFunc(spans, func(a, b fieldSpan) int {
+		return strings.Compare(a.key, b.key)
+	})
+}
+
+// groupPrefixCodes returns the group prefixes that are used by more
+// than one of the given fields, mapped to codes numbered from 1 in order
+// of first use, along with the prefixes in code order.
+func groupPrefixCodes(spans []fieldSpan) (map[string]int, []string) {
+	counts := make(map[string]int)
+	var order []string
+	for _, f := range spans {
+		if f.prefixLen == 0 {
+			continue
+		}
+		p := f.key[:f.prefixLen]
+		if counts[p] == 0 {
+			order = append(order, p)
+		}
+		counts[p]++
+	}
+	codes := make(map[string]int)
+	var legend []string
+	for _, p := range order {
+		if counts[p] > 1 {
+			legend = append(legend, p)
+			codes[p] = len(legend)
+		}
+	}
+	return codes, legend
+}
+
+// abbrevCode returns the key used for the given group prefix code.
+func abbrevCode(code int) string {
+	return "@" + strconv.Itoa(code)
+}
+
+// ExpandGroupPrefixes expands the group prefix abbreviations in a line
+// written with [Options.AbbreviateGroupPrefixes], removing the legend
+// and returning the line as it would have been written without
+// the option. It assumes the default key/value and field separators.
+func ExpandGroupPrefixes(line string) string {
+	tokens := splitFields(line)
+	prefixes := make(map[string]string)
+	out := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		key, val, ok := splitKV(tok)
+		if !ok || !strings.HasPrefix(key, "@") {
+			out = append(out, tok)
+			continue
+		}
+		code, rest, grouped := strings.Cut(key, keyComponentSep)
+		if !grouped {
+			if v, err := unquoteIfQuoted(val); err == nil {
+				prefixes[code] = v
+				continue
+			}
+			out = append(out, tok)
+			continue
+		}
+		prefix, ok := prefixes[code]
+		if !ok {
+			out = append(out, tok)
+			continue
+		}
+		fullKey := prefix + keyComponentSep + rest
+		if needsQuoting(fullKey) {
+			fullKey = strconv.Quote(fullKey)
+		}
+		out = append(out, fullKey+"="+val)
+	}
+	return strings.Join(out, " ")
+}
+
+// splitFields splits a line into space-separated fields,
+// treating quoted strings as part of a field.
+func splitFields(line string) []string {
+	var fields []string
+	for line != "" {
+		i := 0
+		for i < len(line) && line[i] != ' ' {
+			if line[i] == '"' {
+				q, err := strconv.QuotedPrefix(line[i:])
+				if err == nil {
+					i += len(q)
+					continue
+				}
+			}
+			i++
+		}
+		fields = append(fields, line[:i])
+		line = strings.TrimPrefix(line[i:], " ")
+	}
+	return fields
+}
+
+// splitKV splits a field into its key and (still quoted) value.
+// The key is unquoted.
+func splitKV(tok string) (key, val string, ok bool) {
+	if strings.HasPrefix(tok, `"`) {
+		q, err := strconv.QuotedPrefix(tok)
+		if err != nil || !strings.HasPrefix(tok[len(q):], "=") {
+			return "", "", false
+		}
+		key, _ = strconv.Unquote(q)
+		return key, tok[len(q)+1:], true
+	}
+	return strings.Cut(tok, "=")
+}
+
+func unquoteIfQuoted(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
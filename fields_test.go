@@ -0,0 +1,77 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestAbbreviateGroupPrefixes(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		opts    Options
+		with    func(slog.Handler) slog.Handler
+		attrs   []slog.Attr
+		want    string
+		wantRaw string
+	}{
+		{
+			name: "repeated prefix",
+			attrs: []slog.Attr{
+				slog.Group("service", slog.Group("db", slog.Group("conn", slog.Bool("open", true), slog.Int("idle", 3)))),
+				slog.Group("other", slog.Int("x", 1)),
+				slog.Int("top", 2),
+			},
+			want:    `level=INFO msg=m @1=service.db.conn @1.open=true @1.idle=3 other.x=1 top=2`,
+			wantRaw: `level=INFO msg=m service.db.conn.open=true service.db.conn.idle=3 other.x=1 top=2`,
+		},
+		{
+			name: "preformatted and quoted",
+			with: func(h slog.Handler) slog.Handler {
+				return h.WithGroup("a b").WithAttrs([]slog.Attr{slog.Int("p", 1)})
+			},
+			attrs: []slog.Attr{
+				slog.String("q", "x y"),
+				slog.Group("g", slog.Int("c", 2), slog.Int("d", 3)),
+			},
+			want:    `level=INFO msg=m @1="a b" @2="a b.g" @1.p=1 @1.q="x y" @2.c=2 @2.d=3`,
+			wantRaw: `level=INFO msg=m "a b.p"=1 "a b.q"="x y" "a b.g.c"=2 "a b.g.d"=3`,
+		},
+		{
+			name: "with SortKeys",
+			opts: Options{SortKeys: true},
+			attrs: []slog.Attr{
+				slog.Group("g", slog.Int("z", 1), slog.Int("a", 2)),
+				slog.Int("b", 3),
+			},
+			want:    `level=INFO msg=m @1=g b=3 @1.a=2 @1.z=1`,
+			wantRaw: `level=INFO msg=m b=3 g.a=2 g.z=1`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := test.opts
+			opts.AbbreviateGroupPrefixes = true
+			var h slog.Handler = NewHandlerWithOptions(&buf, opts)
+			if test.with != nil {
+				h = test.with(h)
+			}
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if got != test.want {
+				t.Errorf("\ngot  %s\nwant %s", got, test.want)
+			}
+			if got, want := ExpandGroupPrefixes(got), test.wantRaw; got != want {
+				t.Errorf("expanded:\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+// Flush flushes any data buffered by the underlying writer.
+// If the writer has a Flush method, as [bufio.Writer] does, Flush
+// calls it; then, if the writer has a Sync method, as [os.File]
+// does, Flush calls that too. For other writers it does nothing
+// and returns nil.
+//
+// Flush holds the lock that serializes writes, so it never
+// runs concurrently with a call to Write by h or any handler
+// derived from it. If a write that exceeded [Options.WriteTimeout]
+// has yet to return, Flush returns [ErrWriteTimeout] without
+// calling the writer.
+func (h *Handler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stalled.Load() {
+		return ErrWriteTimeout
+	}
+	if f, ok := h.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if s, ok := h.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
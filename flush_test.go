@@ -0,0 +1,118 @@
+package slogtext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// syncWriter records the number of calls to Sync.
+type syncWriter struct {
+	bytes.Buffer
+	syncs int
+	err   error
+}
+
+func (w *syncWriter) Sync() error {
+	w.syncs++
+	return w.err
+}
+
+func TestFlushSync(t *testing.T) {
+	w := &syncWriter{}
+	h := NewHandler(w)
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.syncs != 1 {
+		t.Errorf("got %d calls to Sync, want 1", w.syncs)
+	}
+	w.err = errors.New("sync failed")
+	if err := h.WithGroup("g").(*Handler).Flush(); err != w.err {
+		t.Errorf("got error %v, want %v", err, w.err)
+	}
+}
+
+func TestFlushFlush(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	h := NewHandler(bw)
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected output before Flush: %q", buf.String())
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlushNeither(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf)
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingFlushWriter is a blockingWriter with a Flush method
+// that records whether it was called during a Write.
+type blockingFlushWriter struct {
+	*blockingWriter
+	writing atomic.Bool
+	flushes atomic.Int32
+	overlap atomic.Bool
+}
+
+func (w *blockingFlushWriter) Write(p []byte) (int, error) {
+	w.writing.Store(true)
+	defer w.writing.Store(false)
+	return w.blockingWriter.Write(p)
+}
+
+func (w *blockingFlushWriter) Flush() error {
+	if w.writing.Load() {
+		w.overlap.Store(true)
+	}
+	w.flushes.Add(1)
+	return nil
+}
+
+func TestFlushStalled(t *testing.T) {
+	w := &blockingFlushWriter{
+		blockingWriter: &blockingWriter{release: make(chan struct{})},
+	}
+	h := NewHandlerWithOptions(w, Options{WriteTimeout: 20 * time.Millisecond})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	if err := h.Handle(context.Background(), r); err != ErrWriteTimeout {
+		t.Fatalf("got error %v, want ErrWriteTimeout", err)
+	}
+	if err := h.Flush(); err != ErrWriteTimeout {
+		t.Errorf("got error %v, want ErrWriteTimeout", err)
+	}
+	close(w.release)
+	// Wait for the abandoned write to finish.
+	for h.stalled.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.flushes.Load(); got != 1 {
+		t.Errorf("got %d calls to Flush, want 1", got)
+	}
+	if w.overlap.Load() {
+		t.Errorf("Flush called while Write was in progress")
+	}
+}
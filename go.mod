@@ -2,4 +2,8 @@ module github.com/rogpeppe/slogtext
 
 go 1.21
 
-require golang.org/x/exp v0.0.0-20230321023759-10a507213a29
+require (
+	golang.org/x/exp v0.0.0-20230321023759-10a507213a29
+	golang.org/x/text v0.14.0
+	golang.org/x/time v0.5.0
+)
@@ -0,0 +1,86 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"strconv"
+
+	"golang.org/x/exp/slog"
+)
+
+// Keys of the marker attributes that cause a record to be
+// formatted as a Graphite plaintext metric line when
+// [Options.GraphiteMode] is set.
+const (
+	GraphiteMetricKey = "_metric"
+	GraphiteValueKey  = "_value"
+)
+
+// graphiteMetric returns the metric name and value held in r
+// if it carries both a string GraphiteMetricKey attribute and a numeric
+// GraphiteValueKey attribute.
+func graphiteMetric(r slog.Record) (name string, value slog.Value, ok bool) {
+	var haveName, haveValue bool
+	r.Attrs(func(a slog.Attr) {
+		switch a.Key {
+		case GraphiteMetricKey:
+			if a.Value.Kind() == slog.KindString {
+				name, haveName = a.Value.String(), true
+			}
+		case GraphiteValueKey:
+			switch a.Value.Kind() {
+			case slog.KindInt64, slog.KindUint64, slog.KindFloat64:
+				value, haveValue = a.Value, true
+			}
+		}
+	})
+	return name, value, haveName && haveValue && name != ""
+}
+
+// appendGraphite appends a Graphite plaintext line of the form
+//
+//	NAME VALUE TIMESTAMP
+//
+// to buf, without the line terminator. The timestamp is in
+// Unix seconds; if t is zero it is written as -1, which Graphite
+// interprets as the time of receipt.
+func appendGraphite(buf *buffer, name string, v slog.Value, r slog.Record) {
+	appendGraphiteName(buf, name)
+	buf.WriteByte(' ')
+	switch v.Kind() {
+	case slog.KindInt64:
+		*buf = strconv.AppendInt(*buf, v.Int64(), 10)
+	case slog.KindUint64:
+		*buf = strconv.AppendUint(*buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		*buf = strconv.AppendFloat(*buf, v.Float64(), 'g', -1, 64)
+	}
+	buf.WriteByte(' ')
+	if r.Time.IsZero() {
+		buf.WriteString("-1")
+	} else {
+		*buf = strconv.AppendInt(*buf, r.Time.Unix(), 10)
+	}
+}
+
+// appendGraphiteName appends the metric name to buf, replacing
+// any character that is not legal in a Graphite metric path
+// with an underscore.
+func appendGraphiteName(buf *buffer, name string) {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !isGraphiteNameByte(c) {
+			c = '_'
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func isGraphiteNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c == '.' || c == '_' || c == '-'
+}
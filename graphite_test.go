@@ -0,0 +1,73 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestGraphiteMode(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		time  time.Time
+		attrs []slog.Attr
+		want  string
+	}{
+		{
+			name:  "int",
+			time:  testTime,
+			attrs: []slog.Attr{slog.String(GraphiteMetricKey, "app.requests"), slog.Int(GraphiteValueKey, 42)},
+			want:  "app.requests 42 946782245\n",
+		},
+		{
+			name:  "float with sanitized name",
+			time:  testTime,
+			attrs: []slog.Attr{slog.String(GraphiteMetricKey, "db latency/p99"), slog.Float64(GraphiteValueKey, 1.5)},
+			want:  "db_latency_p99 1.5 946782245\n",
+		},
+		{
+			name:  "zero time",
+			attrs: []slog.Attr{slog.String(GraphiteMetricKey, "x"), slog.Uint64(GraphiteValueKey, 1)},
+			want:  "x 1 -1\n",
+		},
+		{
+			name:  "non-numeric value",
+			attrs: []slog.Attr{slog.String(GraphiteMetricKey, "x"), slog.String(GraphiteValueKey, "one")},
+			want:  "level=INFO msg=m _metric=x _value=one\n",
+		},
+		{
+			name:  "no marker",
+			attrs: []slog.Attr{slog.Int("a", 1)},
+			want:  "level=INFO msg=m a=1\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, Options{GraphiteMode: true})
+			r := slog.NewRecord(test.time, slog.LevelInfo, "m", 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGraphiteModeLineTerminator(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{GraphiteMode: true, LineTerminator: "\r\n"})
+	r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.String(GraphiteMetricKey, "x"), slog.Int(GraphiteValueKey, 1))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "x 1 946782245\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
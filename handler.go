@@ -5,43 +5,89 @@
 package slogtext
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"golang.org/x/exp/slog"
 	"runtime"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/message"
 )
 
 // Handler is a Handler that writes Records to an io.Writer as a
 // sequence of key=value pairs separated by spaces and followed by a newline.
 type Handler struct {
-	opts              slog.HandlerOptions
+	opts              Options
 	preformattedAttrs []byte
-	groupPrefix       string   // for text: prefix of groups opened in preformatting
-	groups            []string // all groups started from WithGroup
-	nOpenGroups       int      // the number of groups opened in preformattedAttrs
-	mu                sync.Mutex
+	groupPrefix       string      // for text: prefix of groups opened in preformatting
+	groups            []string    // all groups started from WithGroup
+	nOpenGroups       int         // the number of groups opened in preformattedAttrs
+	mu                *sync.Mutex // shared by all handlers derived from the same NewHandler call
 	w                 io.Writer
+	color             bool         // whether to colorize output
+	ditto             *dittoState  // shared by all derived handlers
+	stats             *recordStats // shared by all derived handlers
+	// preformattedFields holds the positions of the attributes
+	// in preformattedAttrs, when SortKeys is set.
+	preformattedFields []field
+	// goas records the calls to WithAttrs and WithGroup
+	// when Options.recordsGoas reports true.
+	goas []groupOrAttrs
+	// printer formats numbers when Locale is set.
+	printer *message.Printer
+	// stalled is set while a write that timed out is in progress.
+	stalled *atomic.Bool
+	// descriptor holds the format descriptor line written
+	// when SelfDescribing is set. described, guarded by mu,
+	// records whether it has been written.
+	descriptor string
+	described  *bool
+	// bufPool, if non-nil, holds the buffers that records are
+	// formatted into, as configured by InitialBufferSize.
+	bufPool *bufferPool
+	// seq holds the last sequence number when Sequence is set.
+	seq *atomic.Uint64
+	// hostname and pid hold the values written when
+	// IncludeHostname and IncludePID are set.
+	hostname string
+	pid      int
+	// headerWritten, guarded by mu, records whether
+	// WriteHeader has written the header.
+	headerWritten *bool
+	// badGroups holds the group names rejected by StrictGroups.
+	badGroups []*badGroup
 }
 
 func (h *Handler) clone() *Handler {
-	// We can't use assignment because we can't copy the mutex.
-	return &Handler{
-		opts:              h.opts,
-		preformattedAttrs: slices.Clip(h.preformattedAttrs),
-		groupPrefix:       h.groupPrefix,
-		groups:            slices.Clip(h.groups),
-		nOpenGroups:       h.nOpenGroups,
-		w:                 h.w,
-	}
+	// The state shared between derived handlers is held by
+	// pointer, so a shallow copy shares it. Clip the slices so
+	// that appending to them in h2 does not affect h.
+	h2 := *h
+	h2.preformattedAttrs = slices.Clip(h.preformattedAttrs)
+	h2.groups = slices.Clip(h.groups)
+	h2.badGroups = slices.Clip(h.badGroups)
+	h2.preformattedFields = slices.Clip(h.preformattedFields)
+	h2.goas = slices.Clip(h.goas)
+	return &h2
 }
 
 // enabled reports whether l is greater than or equal to the
 // minimum level.
-func (h *Handler) enabled(l slog.Level) bool {
+func (h *Handler) enabled(ctx context.Context, l slog.Level) bool {
+	if f := h.opts.LevelFromContext; f != nil && ctx != nil {
+		if minLevel, ok := f(ctx); ok {
+			return l >= minLevel
+		}
+	}
 	minLevel := slog.LevelInfo
 	if h.opts.Level != nil {
 		minLevel = h.opts.Level.Level()
@@ -51,6 +97,13 @@ func (h *Handler) enabled(l slog.Level) bool {
 
 func (h *Handler) withAttrs(as []slog.Attr) *Handler {
 	h2 := h.clone()
+	if h.opts.recordsGoas() {
+		h2.goas = append(h2.goas, groupOrAttrs{attrs: slices.Clone(as)})
+	}
+	if h.opts.Layout != LayoutText {
+		// Other layouts format the attributes in full for each record.
+		return h2
+	}
 	// Pre-format the attributes as an optimization.
 	prefix := newBuffer()
 	defer prefix.Free()
@@ -58,11 +111,15 @@ func (h *Handler) withAttrs(as []slog.Attr) *Handler {
 	state := h2.newHandleState((*buffer)(&h2.preformattedAttrs), false, "", prefix)
 	defer state.free()
 	state.openGroups()
+	state.collectFields = h.opts.collectsFields()
 	for _, a := range as {
 		state.appendAttr(a)
 	}
 	// Remember the new prefix for later keys.
 	h2.groupPrefix = state.prefix.String()
+	if state.collectFields {
+		h2.preformattedFields = append(slices.Clip(h.preformattedFields), state.fields...)
+	}
 	// Remember how many opened groups are in preformattedAttrs,
 	// so we don't open them again when we handle a Record.
 	h2.nOpenGroups = len(h2.groups)
@@ -73,25 +130,205 @@ func (h *Handler) withGroup(name string) *Handler {
 	if name == "" {
 		return h
 	}
+	if h.opts.MergeAdjacentGroups && len(h.groups) > 0 && h.groups[len(h.groups)-1] == name {
+		return h
+	}
 	h2 := h.clone()
 	h2.groups = append(h2.groups, name)
+	if g := h.checkGroupName(name); g != nil {
+		h2.badGroups = append(h2.badGroups, g)
+	}
+	if h.opts.recordsGoas() {
+		h2.goas = append(h2.goas, groupOrAttrs{group: name})
+	}
 	return h2
 }
 
-func (h *Handler) handle(r slog.Record) error {
-	state := h.newHandleState(newBuffer(), true, "", nil)
+// recordsGoas reports whether the handler needs to record the
+// calls to WithAttrs and WithGroup in goas, because it formats
+// records in some way other than LayoutText.
+func (opts *Options) recordsGoas() bool {
+	return opts.Layout != LayoutText || opts.JSONSidecar
+}
+
+func (h *Handler) handle(ctx context.Context, r slog.Record) (int, error) {
+	buf := h.newRecordBuffer()
+	defer h.freeRecordBuffer(buf)
+	if !h.format(buf, ctx, r) {
+		return 0, nil
+	}
+	n, err := h.write(r.Level, *buf)
+	if err != nil && h.opts.OnError != nil {
+		h.opts.OnError(err)
+	}
+	return n, err
+}
+
+// newRecordBuffer returns a buffer to format a record into.
+func (h *Handler) newRecordBuffer() *buffer {
+	if h.bufPool != nil {
+		return h.bufPool.get()
+	}
+	return newBuffer()
+}
+
+// freeRecordBuffer frees a buffer returned by newRecordBuffer.
+func (h *Handler) freeRecordBuffer(buf *buffer) {
+	if h.bufPool != nil {
+		h.bufPool.put(buf)
+	} else {
+		buf.Free()
+	}
+}
+
+// format appends the formatted form of r to buf, ready to be
+// written. It reports false if r should be discarded instead.
+func (h *Handler) format(buf *buffer, ctx context.Context, r slog.Record) bool {
+	if h.opts.LevelFromContext != nil && !h.enabled(ctx, r.Level) {
+		// The context may differ from the one passed to Enabled.
+		return false
+	}
+	var rv recordValues
+	if h.seq != nil {
+		rv.seq = h.seq.Add(1)
+	}
+	if h.opts.AddMonotonic {
+		rv.mono = monotonicNanos()
+	}
+	if h.opts.RecoverFromPanics {
+		h.appendRecovering(buf, ctx, r, rv)
+	} else {
+		h.appendAll(buf, ctx, r, rv)
+	}
+	if h.stats != nil {
+		h.stats.add(r.Level)
+	}
+	return true
+}
+
+// recordValues holds the values of built-in attributes that are
+// computed once for each record, so that all the lines written
+// for it agree.
+type recordValues struct {
+	seq  uint64 // the sequence number, or zero if Sequence is not set
+	mono int64  // the monotonic clock reading, if AddMonotonic is set
+}
+
+// appendAll appends the line or lines for r to buf.
+func (h *Handler) appendAll(buf *buffer, ctx context.Context, r slog.Record, rv recordValues) {
+	if h.opts.ExplodeKey != "" {
+		h.appendExploded(buf, ctx, r, rv)
+	} else {
+		h.appendRecord(buf, ctx, r, rv)
+	}
+	if h.opts.JSONSidecar {
+		builtins, attrs := h.layoutAttrs(ctx, r, rv)
+		buf.WriteString(h.opts.LinePrefix)
+		appendJSONObject(buf, append(builtins, attrs...), h.errorPrefix())
+		h.appendLineTerminator(buf)
+	}
+}
+
+// appendRecovering is like appendAll except that if formatting
+// panics, it replaces anything already appended by a line holding
+// the level, the message and the panic value.
+func (h *Handler) appendRecovering(buf *buffer, ctx context.Context, r slog.Record, rv recordValues) {
+	start := len(*buf)
+	defer func() {
+		if v := recover(); v != nil {
+			*buf = (*buf)[:start]
+			h.appendPanicRecord(buf, r, v)
+		}
+	}()
+	h.appendAll(buf, ctx, r, rv)
+}
+
+// appendPanicRecord appends a line reporting that formatting r
+// panicked with the value v. It uses none of the handler's
+// options, which may be the cause of the panic, except
+// for LinePrefix and the line terminator, which keep the
+// stream framed as the reader expects.
+func (h *Handler) appendPanicRecord(buf *buffer, r slog.Record, v any) {
+	buf.WriteString(h.opts.LinePrefix)
+	buf.WriteString(slog.LevelKey)
+	buf.WriteByte('=')
+	buf.WriteString(r.Level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(slog.MessageKey)
+	buf.WriteByte('=')
+	appendQuotedIfNeeded(buf, r.Message)
+	buf.WriteString(" error=")
+	appendQuotedIfNeeded(buf, fmt.Sprintf("!ERROR:panic:%v", v))
+	h.appendLineTerminator(buf)
+}
+
+func appendQuotedIfNeeded(buf *buffer, s string) {
+	if needsQuoting(s) {
+		*buf = strconv.AppendQuote(*buf, s)
+	} else {
+		buf.WriteString(s)
+	}
+}
+
+// appendRecord appends the formatted record, including its
+// terminating newline, to dst.
+func (h *Handler) appendRecord(dst *buffer, ctx context.Context, r slog.Record, rv recordValues) {
+	if len(*dst) > 0 || h.opts.LinePrefix != "" {
+		// The handleState relies on the buffer holding only the
+		// current record when deciding whether to write a separator,
+		// so format into a separate buffer.
+		buf := h.newRecordBuffer()
+		defer h.freeRecordBuffer(buf)
+		h.appendUnprefixedRecord(buf, ctx, r, rv)
+		dst.WriteString(h.opts.LinePrefix)
+		dst.Write(*buf)
+		return
+	}
+	h.appendUnprefixedRecord(dst, ctx, r, rv)
+}
+
+// appendUnprefixedRecord is like appendRecord but omits the
+// LinePrefix, and requires dst to be empty.
+func (h *Handler) appendUnprefixedRecord(dst *buffer, ctx context.Context, r slog.Record, rv recordValues) {
+	if h.opts.GraphiteMode {
+		if name, val, ok := graphiteMetric(r); ok {
+			appendGraphite(dst, name, val, r)
+			h.appendLineTerminator(dst)
+			return
+		}
+	}
+	switch h.opts.Layout {
+	case LayoutProtoText:
+		builtins, attrs := h.layoutAttrs(ctx, r, rv)
+		appendProtoText(dst, append(builtins, attrs...), h.errorPrefix())
+		h.appendLineTerminator(dst)
+		return
+	case LayoutXML:
+		builtins, attrs := h.layoutAttrs(ctx, r, rv)
+		appendXML(dst, builtins, attrs, h.errorPrefix())
+		h.appendLineTerminator(dst)
+		return
+	}
+	state := h.newHandleState(dst, false, "", nil)
 	defer state.free()
 	// Built-in attributes. They are not in a group.
 	stateGroups := state.groups
 	state.groups = nil // So ReplaceAttrs sees no groups instead of the pre groups.
 	rep := h.opts.ReplaceAttr
 	// time
+	builtinRep := &h.opts.ReplaceBuiltins
 	if !r.Time.IsZero() {
 		key := slog.TimeKey
-		val := r.Time.Round(0) // strip monotonic to match Attr behavior
-		if rep == nil {
+		val := h.inTimeLocation(r.Time.Round(0)) // strip monotonic to match Attr behavior
+		if builtinRep.Time != nil {
+			val = builtinRep.Time(val)
+		}
+		if val.IsZero() {
+			// Omitted by ReplaceBuiltins.
+		} else if rep == nil {
 			state.appendKey(key)
 			state.appendTime(val)
+			state.appendBuiltinAlias(key)
 		} else {
 			state.appendAttr(slog.Time(key, val))
 		}
@@ -99,47 +336,283 @@ func (h *Handler) handle(r slog.Record) error {
 	// level
 	key := slog.LevelKey
 	val := r.Level
-	if rep == nil {
+	levelStart := len(*state.buf)
+	if builtinRep.Level != nil {
+		if label := builtinRep.Level(val); label == "" {
+			// Omitted by ReplaceBuiltins.
+		} else if rep == nil {
+			state.appendKey(key)
+			state.appendString(label)
+		} else {
+			state.appendAttr(slog.String(key, label))
+		}
+	} else if rep == nil {
 		state.appendKey(key)
-		state.appendString(val.String())
+		state.appendString(h.levelLabel(val))
 	} else {
 		state.appendAttr(slog.Any(key, val))
 	}
+	if h.color && !h.opts.ColorLine && len(*state.buf) > levelStart {
+		state.colorFrom(state.valueStart, h.levelColor(val))
+	}
+	if rep == nil && len(*state.buf) > levelStart {
+		state.appendBuiltinAlias(key)
+	}
 	// source
-	if h.opts.AddSource {
+	if h.addsSource(r.Level) {
 		frame := recordFrame(r)
 		if frame.File != "" {
 			key := slog.SourceKey
-			if rep == nil {
+			fn, file := h.sourceParts(frame)
+			if h.opts.SourceAsGroup {
+				state.appendSourceGroup(h.sourceGroup(frame))
+			} else if builtinRep.Source != nil {
+				if src := builtinRep.Source(sourceString(fn, file, frame.Line)); src == "" {
+					// Omitted by ReplaceBuiltins.
+				} else if rep == nil {
+					state.appendKey(key)
+					state.appendString(src)
+					state.appendBuiltinAlias(key)
+				} else {
+					state.appendAttr(slog.String(key, src))
+				}
+			} else if rep == nil {
 				state.appendKey(key)
-				state.appendSource(frame.File, frame.Line)
+				state.appendSource(fn, file, frame.Line)
+				state.appendBuiltinAlias(key)
 			} else {
-				buf := newBuffer()
-				buf.WriteString(frame.File) // TODO: escape?
-				buf.WriteByte(':')
-				buf.WritePosInt(frame.Line)
-				s := buf.String()
-				buf.Free()
-				state.appendAttr(slog.String(key, s))
+				state.appendAttr(slog.String(key, sourceString(fn, file, frame.Line)))
 			}
 		}
 	}
 	key = slog.MessageKey
 	msg := r.Message
+	if builtinRep.Message != nil {
+		msg = builtinRep.Message(msg)
+	}
+	msg = h.flattenNewlines(msg)
 	if rep == nil {
 		state.appendKey(key)
 		state.appendString(msg)
+		state.appendBuiltinAlias(key)
 	} else {
 		state.appendAttr(slog.String(key, msg))
 	}
+	// sequence number
+	if rv.seq != 0 {
+		if rep == nil {
+			state.appendKey(SequenceKey)
+			start := len(*state.buf)
+			*state.buf = strconv.AppendUint(*state.buf, rv.seq, 10)
+			state.quoteAllFrom(start)
+			state.appendBuiltinAlias(SequenceKey)
+		} else {
+			state.appendAttr(slog.Uint64(SequenceKey, rv.seq))
+		}
+	}
+	// request ID
+	if h.opts.AddRequestID {
+		if id, ok := RequestIDFromContext(ctx); ok {
+			if rep == nil {
+				state.appendKey(RequestIDKey)
+				state.appendString(id)
+				state.appendBuiltinAlias(RequestIDKey)
+			} else {
+				state.appendAttr(slog.String(RequestIDKey, id))
+			}
+		}
+	}
+	// monotonic clock
+	if h.opts.AddMonotonic {
+		mono := rv.mono
+		if rep == nil {
+			state.appendKey(MonotonicKey)
+			start := len(*state.buf)
+			*state.buf = strconv.AppendInt(*state.buf, mono, 10)
+			state.quoteAllFrom(start)
+			state.appendBuiltinAlias(MonotonicKey)
+		} else {
+			state.appendAttr(slog.Int64(MonotonicKey, mono))
+		}
+	}
+	// tags
+	for _, rule := range h.opts.TagRules {
+		if !rule.Match(r) {
+			continue
+		}
+		if rep == nil {
+			state.appendKey(TagKey)
+			state.appendString(rule.Tag)
+			state.appendBuiltinAlias(TagKey)
+		} else {
+			state.appendAttr(slog.String(TagKey, rule.Tag))
+		}
+	}
+	// host and process ID
+	if h.hostname != "" {
+		if rep == nil {
+			state.appendKey(HostKey)
+			state.appendString(h.hostname)
+			state.appendBuiltinAlias(HostKey)
+		} else {
+			state.appendAttr(slog.String(HostKey, h.hostname))
+		}
+	}
+	if h.pid != 0 {
+		if rep == nil {
+			state.appendKey(PIDKey)
+			start := len(*state.buf)
+			*state.buf = strconv.AppendInt(*state.buf, int64(h.pid), 10)
+			state.quoteAllFrom(start)
+			state.appendBuiltinAlias(PIDKey)
+		} else {
+			state.appendAttr(slog.Int(PIDKey, h.pid))
+		}
+	}
+	state.appendBadGroups()
 	state.groups = stateGroups // Restore groups passed to ReplaceAttrs.
-	state.appendNonBuiltIns(r)
-	state.buf.WriteByte('\n')
+	builtinsEnd := len(*state.buf)
+	state.appendNonBuiltIns(ctx, r)
+	if h.opts.AddDedupHash {
+		// Truncate before appending the hash so that it is
+		// never removed, leaving room for it.
+		attrsEnd := len(*state.buf)
+		state.appendDedupHash()
+		reserve := len(*state.buf) - attrsEnd
+		*state.buf = (*state.buf)[:attrsEnd]
+		state.truncateRecordFrom(builtinsEnd, reserve)
+		state.appendDedupHash()
+	} else {
+		state.truncateRecordFrom(builtinsEnd, 0)
+	}
+	if h.color && h.opts.ColorLine {
+		state.colorFrom(0, h.levelColor(r.Level))
+	}
+	h.appendLineTerminator(state.buf)
+}
+
+// appendLineTerminator appends the text that ends each line,
+// as determined by LineTerminator and NoNewline.
+func (h *Handler) appendLineTerminator(buf *buffer) {
+	switch {
+	case h.opts.LineTerminator != "":
+		buf.WriteString(h.opts.LineTerminator)
+	case !h.opts.NoNewline:
+		buf.WriteByte('\n')
+	}
+}
 
+// write writes a single formatted record at the given level
+// with one call to Write, and returns the number of bytes written.
+func (h *Handler) write(level slog.Level, p []byte) (int, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	_, err := h.w.Write(*state.buf)
-	return err
+	return h.writeLocked(level, p)
+}
+
+// writeLocked is like write but is called with h.mu held.
+func (h *Handler) writeLocked(level slog.Level, p []byte) (int, error) {
+	if h.descriptor != "" && !*h.described {
+		*h.described = true
+		buf := newBuffer()
+		defer buf.Free()
+		buf.WriteString(h.descriptor)
+		buf.Write(p)
+		p = *buf
+	}
+	w := h.writerFor(level)
+	if h.opts.UseReaderFrom {
+		if rf, ok := w.(io.ReaderFrom); ok {
+			w = readFromWriter{rf}
+		}
+	}
+	if h.opts.WriteRetries > 0 {
+		return h.writeRetrying(w, p)
+	}
+	return h.writeOnce(w, p)
+}
+
+// writeOnce writes p to w with one call to Write, limited by
+// the WriteTimeout if there is one.
+func (h *Handler) writeOnce(w io.Writer, p []byte) (int, error) {
+	if h.opts.WriteTimeout > 0 {
+		return h.writeWithTimeout(w, p)
+	}
+	return w.Write(p)
+}
+
+// readFromWriter implements Write by calling ReadFrom.
+type readFromWriter struct {
+	rf io.ReaderFrom
+}
+
+func (w readFromWriter) Write(p []byte) (int, error) {
+	n, err := w.rf.ReadFrom(bytes.NewReader(p))
+	if err == nil && n < int64(len(p)) {
+		err = io.ErrShortWrite
+	}
+	return int(n), err
+}
+
+// contextAttrs returns the attributes extracted from ctx
+// by the ContextAttrs functions. It returns nil if ctx is nil.
+func (h *Handler) contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil || len(h.opts.ContextAttrs) == 0 {
+		return nil
+	}
+	var attrs []slog.Attr
+	for _, f := range h.opts.ContextAttrs {
+		attrs = append(attrs, f(ctx)...)
+	}
+	return attrs
+}
+
+// levelLabel returns the text for the built-in level attribute.
+func (h *Handler) levelLabel(l slog.Level) string {
+	if label, ok := h.opts.LevelLabels[l]; ok {
+		return label
+	}
+	if h.opts.LowercaseLevels {
+		switch l {
+		case slog.LevelDebug:
+			return "debug"
+		case slog.LevelInfo:
+			return "info"
+		case slog.LevelWarn:
+			return "warn"
+		case slog.LevelError:
+			return "error"
+		}
+	}
+	return l.String()
+}
+
+// flattenNewlines returns s with each line break replaced by
+// the FlattenNewlines marker, if there is one.
+func (h *Handler) flattenNewlines(s string) string {
+	marker := h.opts.FlattenNewlines
+	if marker == "" || strings.IndexByte(s, '\n') < 0 {
+		return s
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", marker)
+}
+
+// inTimeLocation returns t in the TimeLocation, if there is one.
+func (h *Handler) inTimeLocation(t time.Time) time.Time {
+	if loc := h.opts.TimeLocation; loc != nil {
+		return t.In(loc)
+	}
+	return t
+}
+
+// addsSource reports whether the source location should
+// be added to a record at the given level.
+func (h *Handler) addsSource(level slog.Level) bool {
+	return h.opts.AddSource || h.opts.SourceLevel != nil && level >= h.opts.SourceLevel.Level()
+}
+
+func (h *Handler) writerFor(level slog.Level) io.Writer {
+	return h.w
 }
 
 func recordFrame(r slog.Record) runtime.Frame {
@@ -148,13 +621,20 @@ func recordFrame(r slog.Record) runtime.Frame {
 	return f
 }
 
-func (s *handleState) appendNonBuiltIns(r slog.Record) {
+func (s *handleState) appendNonBuiltIns(ctx context.Context, r slog.Record) {
+	start := len(*s.buf)
 	// preformatted Attrs
 	if len(s.h.preformattedAttrs) > 0 {
 		if len(*s.buf) > 0 {
-			s.buf.WriteString(" ")
+			s.appendFieldSep()
 		}
+		base := len(*s.buf)
 		s.buf.Write(s.h.preformattedAttrs)
+		for _, f := range s.h.preformattedFields {
+			f.start += base
+			f.valueStart += base
+			s.fields = append(s.fields, f)
+		}
 	}
 	// Attrs in Record -- unlike the built-in ones, they are in groups started
 	// from WithGroup.
@@ -162,9 +642,20 @@ func (s *handleState) appendNonBuiltIns(r slog.Record) {
 	defer s.prefix.Free()
 	s.prefix.WriteString(s.h.groupPrefix)
 	s.openGroups()
+	s.inRecord = true
+	s.collectFields = s.h.opts.collectsFields()
+	for _, a := range s.h.contextAttrs(ctx) {
+		s.appendAttr(a)
+	}
 	r.Attrs(func(a slog.Attr) {
 		s.appendAttr(a)
 	})
+	if s.h.opts.AddDedupHash {
+		s.dedupHash = s.hashContent(r.Level, r.Message)
+	}
+	if s.h.opts.rewritesFields() {
+		s.rewriteFields(start)
+	}
 }
 
 // handleState holds state for a single call to Handler.handle.
@@ -173,9 +664,22 @@ func (s *handleState) appendNonBuiltIns(r slog.Record) {
 type handleState struct {
 	h       *Handler
 	buf     *buffer
-	freeBuf bool      // should buf be freed?
-	prefix  *buffer   // for text: key prefix
-	groups  *[]string // pool-allocated slice of active groups, for ReplaceAttr
+	freeBuf bool         // should buf be freed?
+	prefix  *buffer      // for text: key prefix
+	groups  *[]string    // pool-allocated slice of active groups, for ReplaceAttr
+	json    *jsonEncoder // pool-allocated JSON encoder, shared by all values
+	// keyStart and valueStart hold the offsets in buf of the
+	// most recently started key and value.
+	keyStart   int
+	valueStart int
+	// inRecord is set while appending the record's own attributes.
+	inRecord bool
+	// collectFields is set when the position of each
+	// attribute should be recorded in fields, for SortKeys.
+	collectFields bool
+	fields        []field
+	// dedupHash holds the hash of the record for AddDedupHash.
+	dedupHash uint64
 }
 
 var groupPool = sync.Pool{New: func() any {
@@ -205,6 +709,21 @@ func (s *handleState) free() {
 		*gs = (*gs)[:0]
 		groupPool.Put(gs)
 	}
+	if s.json != nil {
+		s.json.free()
+	}
+}
+
+// jsonEncoder returns the JSON encoder for s,
+// allocating it on first use.
+func (s *handleState) jsonEncoder() *jsonEncoder {
+	if s.json == nil {
+		s.json = newJSONEncoder()
+		if indent := s.h.opts.JSONIndent; indent != "" {
+			s.json.enc.SetIndent("", indent)
+		}
+	}
+	return s.json
 }
 
 func (s *handleState) openGroups() {
@@ -213,14 +732,22 @@ func (s *handleState) openGroups() {
 	}
 }
 
-// Separator for group names and keys.
-const keyComponentSep = '.'
+// Default separator for group names and keys.
+const keyComponentSep = "."
+
+// groupSep returns the separator for group names and keys.
+func (s *handleState) groupSep() string {
+	if sep := s.h.opts.GroupSeparator; sep != "" {
+		return sep
+	}
+	return keyComponentSep
+}
 
 // openGroup starts a new group of attributes
 // with the given name.
 func (s *handleState) openGroup(name string) {
 	s.prefix.WriteString(name)
-	s.prefix.WriteByte(keyComponentSep)
+	s.prefix.WriteString(s.groupSep())
 	// Collect group names for ReplaceAttr.
 	if s.groups != nil {
 		*s.groups = append(*s.groups, name)
@@ -230,7 +757,7 @@ func (s *handleState) openGroup(name string) {
 
 // closeGroup ends the group with the given name.
 func (s *handleState) closeGroup(name string) {
-	(*s.prefix) = (*s.prefix)[:len(*s.prefix)-len(name)-1 /* for keyComponentSep */]
+	(*s.prefix) = (*s.prefix)[:len(*s.prefix)-len(name)-len(s.groupSep())]
 	if s.groups != nil {
 		*s.groups = (*s.groups)[:len(*s.groups)-1]
 	}
@@ -240,7 +767,18 @@ func (s *handleState) closeGroup(name string) {
 // It handles replacement and checking for an empty key.
 // after replacement).
 func (s *handleState) appendAttr(a slog.Attr) {
-	v := a.Value
+	// Attributes in the Record are already resolved, but those
+	// passed to WithAttrs, and those in groups, may not be.
+	fromLogValuer := a.Value.Kind() == slog.KindLogValuer
+	v := s.resolve(a.Value)
+	if fromLogValuer && v.Kind() == slog.KindGroup && a.Key != "" {
+		switch s.h.opts.LogValuerGroupMode {
+		case GroupInline:
+			a.Key = ""
+		case GroupJSON:
+			v = jsonGroupValue(v.Group(), s.errorPrefix())
+		}
+	}
 	// Elide a non-group with an empty key.
 	if a.Key == "" && v.Kind() != slog.KindGroup {
 		return
@@ -250,13 +788,16 @@ func (s *handleState) appendAttr(a slog.Attr) {
 		if s.groups != nil {
 			gs = *s.groups
 		}
-		a = rep(gs, slog.Attr{a.Key, v})
+		a = rep(gs, slog.Attr{Key: a.Key, Value: v})
 		if a.Key == "" {
 			return
 		}
 		// Although all attributes in the Record are already resolved,
 		// This one came from the user, so it may not have been.
-		v = a.Value.Resolve()
+		v = s.resolve(a.Value)
+	}
+	if s.h.opts.OmitNil && v.Kind() == slog.KindAny && isNilPointer(v.Any()) {
+		return
 	}
 	if v.Kind() == slog.KindGroup {
 		attrs := v.Group()
@@ -274,55 +815,568 @@ func (s *handleState) appendAttr(a slog.Attr) {
 			}
 		}
 	} else {
-		s.appendKey(a.Key)
-		s.appendValue(v)
+		nfields := len(s.fields)
+		key, ok := s.checkKey(a.Key)
+		alias, hasAlias := s.alias(a.Key)
+		if s.h.opts.BareBoolFlags && v.Kind() == slog.KindBool {
+			if ok {
+				s.appendFlag(key, v.Bool())
+			}
+			if hasAlias {
+				s.appendFlag(alias, v.Bool())
+			}
+			s.setFieldHashes(nfields, v)
+			return
+		}
+		if ok {
+			s.appendKey(key)
+			if s.inRecord && s.h.ditto != nil && s.h.ditto.keys[a.Key] {
+				s.appendDittoValue(a.Key, v)
+			} else {
+				s.appendKeyedValue(a.Key, v)
+			}
+			if s.h.opts.ErrorStackTrace {
+				s.appendStackTrace(key, v)
+			}
+		}
+		if hasAlias {
+			s.appendKey(alias)
+			s.appendKeyedValue(a.Key, v)
+		}
+		s.setFieldHashes(nfields, v)
+	}
+}
+
+// checkKey returns the key to write for an attribute with the given
+// key, as determined by KeyPattern and BadKeyString. It reports false
+// if the attribute should be dropped.
+func (s *handleState) checkKey(key string) (string, bool) {
+	if p := s.h.opts.KeyPattern; p != nil && !p.MatchString(key) {
+		switch s.h.opts.KeyMismatch {
+		case KeyMismatchDrop:
+			return "", false
+		case KeyMismatchFlag:
+			key = "!" + key
+		case KeyMismatchRename:
+			key = badKey
+		}
+	}
+	if key == badKey && s.h.opts.BadKeyString != "" {
+		key = s.h.opts.BadKeyString
+	}
+	return key, true
+}
+
+// alias returns the alias for key from KeyAliases, checked as
+// by checkKey. It reports false if there is none or if the
+// alias should be dropped.
+func (s *handleState) alias(key string) (string, bool) {
+	alias, ok := s.h.opts.KeyAliases[key]
+	if !ok {
+		return "", false
+	}
+	return s.checkKey(alias)
+}
+
+// appendBuiltinAlias repeats the built-in attribute with the given
+// key, just appended without calling appendAttr, under its alias,
+// as appendAttr does for other attributes.
+func (s *handleState) appendBuiltinAlias(key string) {
+	alias, ok := s.alias(key)
+	if !ok {
+		return
 	}
+	start, end := s.valueStart, len(*s.buf)
+	s.appendKey(alias)
+	*s.buf = append(*s.buf, (*s.buf)[start:end]...)
+}
+
+// jsonGroupValue returns a value that is formatted as a JSON
+// object holding attrs, for [GroupJSON]. Values that cannot be
+// formatted are written as errors preceded by errPrefix.
+func jsonGroupValue(attrs []slog.Attr, errPrefix string) slog.Value {
+	buf := newBuffer()
+	defer buf.Free()
+	appendJSONObject(buf, resolveAttrs(attrs), errPrefix)
+	return slog.AnyValue(json.RawMessage(buf.String()))
+}
+
+// resolve returns v resolved as by [slog.Value.Resolve], except that
+// if MaxResolveDepth is set, it calls LogValue at most that many
+// times, returning an error string if that does not suffice.
+func (s *handleState) resolve(v slog.Value) slog.Value {
+	max := s.h.opts.MaxResolveDepth
+	if s.h.opts.LogValuerGroupMode != GroupPrefixed {
+		// Resolve only the value itself, leaving the values in
+		// any group to be resolved when they are appended, so
+		// that appendAttr can tell which groups came from LogValuers.
+		if v.Kind() != slog.KindLogValuer {
+			return v
+		}
+		if max <= 0 {
+			max = maxLogValues
+		}
+	}
+	if max <= 0 || v.Kind() != slog.KindLogValuer {
+		return v.Resolve()
+	}
+	for i := 0; i < max; i++ {
+		v = logValue(v.LogValuer())
+		if v.Kind() != slog.KindLogValuer {
+			return v
+		}
+	}
+	return slog.StringValue(s.errorPrefix() + "logvaluer depth exceeded")
+}
+
+// maxLogValues is the number of times LogValue is called
+// when resolving a value, as in slog, if MaxResolveDepth is not set.
+const maxLogValues = 100
+
+// logValue returns lv.LogValue(), or an error value
+// if it panics, as slog.Value.Resolve does.
+func logValue(lv slog.LogValuer) (v slog.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = slog.AnyValue(fmt.Errorf("LogValue panicked: %v", r))
+		}
+	}()
+	return lv.LogValue()
+}
+
+// appendKeyedValue appends the value of the attribute with the
+// given key (not including any group prefix), applying any
+// options that depend on the key.
+func (s *handleState) appendKeyedValue(key string, v slog.Value) {
+	start := len(*s.buf)
+	if len(s.h.opts.ValueFormatters) > 0 || len(s.h.opts.HexKeys) > 0 {
+		fullKey := key
+		if s.prefix != nil {
+			fullKey = string(*s.prefix) + key
+		}
+		if f, ok := s.h.opts.ValueFormatters[fullKey]; ok {
+			f(v, (*[]byte)(s.buf))
+			s.quoteFrom(start)
+			return
+		}
+		if s.h.opts.HexKeys[fullKey] && (v.Kind() == slog.KindInt64 || v.Kind() == slog.KindUint64) {
+			*s.buf = appendHexInt(*s.buf, v)
+			s.quoteAllFrom(start)
+			return
+		}
+	}
+	s.appendValue(v)
+	if unit, ok := s.h.opts.KeyUnits[key]; ok && isNumber(v.Kind()) {
+		s.buf.WriteString(unit)
+		s.quoteFrom(start)
+	}
+}
+
+// appendHexInt appends the integer value v to dst in hexadecimal
+// with a 0x prefix, as for the HexKeys option.
+func appendHexInt(dst []byte, v slog.Value) []byte {
+	var u uint64
+	if v.Kind() == slog.KindUint64 {
+		u = v.Uint64()
+	} else if i := v.Int64(); i < 0 {
+		dst = append(dst, '-')
+		u = uint64(-i)
+	} else {
+		u = uint64(i)
+	}
+	dst = append(dst, "0x"...)
+	return strconv.AppendUint(dst, u, 16)
+}
+
+// isNumber reports whether values of kind k are numbers.
+func isNumber(k slog.Kind) bool {
+	return k == slog.KindInt64 || k == slog.KindUint64 || k == slog.KindFloat64
 }
 
 func (s *handleState) appendError(err error) {
-	s.appendString(fmt.Sprintf("!ERROR:%v", err))
+	s.appendString(fmt.Sprintf("%s%v", s.errorPrefix(), err))
+}
+
+// errorPrefix is a shorthand for s.h.errorPrefix().
+func (s *handleState) errorPrefix() string {
+	return s.h.errorPrefix()
+}
+
+// errorPrefix returns the prefix of values written
+// in place of values that cannot be formatted.
+func (h *Handler) errorPrefix() string {
+	if p := h.opts.ErrorPrefix; p != "" {
+		return p
+	}
+	return "!ERROR:"
 }
 
 func (s *handleState) appendKey(key string) {
+	s.appendKeyName("", key)
+	if align := s.h.opts.AlignKeys; align > 0 {
+		keyLen := utf8.RuneCount((*s.buf)[s.keyStart:])
+		s.appendKVSep()
+		for i := keyLen; i < align; i++ {
+			s.buf.WriteByte(' ')
+		}
+	} else {
+		s.appendKVSep()
+	}
+	s.valueStart = len(*s.buf)
+	if s.collectFields {
+		s.fields[len(s.fields)-1].valueStart = s.valueStart
+	}
+}
+
+// appendFlag appends a boolean attribute as a bare key, as
+// for the BareBoolFlags option.
+func (s *handleState) appendFlag(key string, b bool) {
+	mark := ""
+	if !b {
+		if !s.h.opts.NegateFalseFlags {
+			return
+		}
+		mark = "!"
+	}
+	s.appendKeyName(mark, key)
+	s.valueStart = len(*s.buf)
+	if s.collectFields {
+		f := &s.fields[len(s.fields)-1]
+		f.valueStart = s.valueStart
+		f.bare = true
+		f.negated = !b
+	}
+}
+
+// appendKeyName appends the fully qualified key, preceded by
+// mark and by a field separator if necessary.
+func (s *handleState) appendKeyName(mark, key string) {
 	if len(*s.buf) > 0 {
-		s.buf.WriteString(" ")
+		s.appendFieldSep()
 	}
-	if s.prefix != nil {
+	s.keyStart = len(*s.buf)
+	if s.collectFields {
+		f := field{key: key, start: len(*s.buf)}
+		if s.prefix != nil {
+			f.key = string(*s.prefix) + key
+			f.prefixLen = len(*s.prefix)
+		}
+		s.fields = append(s.fields, f)
+	}
+	switch max := s.h.opts.MaxKeyBytes; {
+	case max > 0 && s.prefix != nil && len(*s.prefix)+len(key) > max:
+		s.appendKeyString(mark + truncateKey(string(*s.prefix)+key, max))
+	case max > 0 && s.prefix == nil && len(key) > max:
+		s.appendKeyString(mark + truncateKey(key, max))
+	case s.prefix != nil:
 		// TODO: optimize by avoiding allocation.
-		s.appendString(string(*s.prefix) + key)
-	} else {
-		s.appendString(key)
+		s.appendKeyString(mark + string(*s.prefix) + key)
+	case mark != "":
+		s.appendKeyString(mark + key)
+	default:
+		s.appendKeyString(key)
+	}
+}
+
+// truncatedKeyMarker is appended to keys truncated because
+// of the MaxKeyBytes option.
+const truncatedKeyMarker = "…"
+
+// truncateKey truncates key to at most max bytes without
+// splitting a UTF-8 sequence, and appends truncatedKeyMarker.
+func truncateKey(key string, max int) string {
+	return truncateString(key, max) + truncatedKeyMarker
+}
+
+// trimSourcePath returns the part of the source file path
+// to be written in the given mode. Both slash and backslash
+// are treated as path separators.
+func trimSourcePath(file string, mode SourceMode) string {
+	switch mode {
+	case SourceShort:
+		return file[strings.LastIndexAny(file, `/\`)+1:]
+	case SourcePackage:
+		if i := strings.LastIndexAny(file, `/\`); i >= 0 {
+			return file[strings.LastIndexAny(file[:i], `/\`)+1:]
+		}
+	}
+	return file
+}
+
+// sourceParts returns the function name and file path to be
+// written for the source location in frame. Either may be empty.
+// The function name is qualified by the last element of its package
+// path, as in slogtext.TestHandler.
+func (h *Handler) sourceParts(frame runtime.Frame) (fn, file string) {
+	if h.opts.SourcePrivacy {
+		return shortFunction(frame.Function), ""
+	}
+	file = trimSourcePath(frame.File, h.opts.SourceMode)
+	if h.opts.SourceWithFunction {
+		fn = shortFunction(frame.Function)
+	}
+	return fn, file
+}
+
+// sourceString returns the source location as written
+// by appendSource, without quoting.
+func sourceString(fn, file string, line int) string {
+	buf := newBuffer()
+	defer buf.Free()
+	buf.WriteString(fn)
+	if fn != "" && file != "" {
+		buf.WriteByte('@')
+	}
+	buf.WriteString(file) // TODO: escape?
+	buf.WriteByte(':')
+	buf.WritePosInt(line)
+	return buf.String()
+}
+
+// shortFunction returns the function name fn qualified
+// by the last element of its package path only.
+func shortFunction(fn string) string {
+	// Package paths may contain dots, but not after the last slash.
+	return fn[strings.LastIndexByte(fn, '/')+1:]
+}
+
+// sourceGroup returns the source location as a group
+// for the SourceAsGroup option.
+func (h *Handler) sourceGroup(frame runtime.Frame) slog.Attr {
+	var attrs []slog.Attr
+	if !h.opts.SourcePrivacy {
+		attrs = append(attrs, slog.String("file", trimSourcePath(frame.File, h.opts.SourceMode)))
+	}
+	attrs = append(attrs, slog.Int("line", frame.Line))
+	if frame.Function != "" {
+		attrs = append(attrs, slog.String("function", shortFunction(frame.Function)))
+	}
+	return slog.Attr{Key: slog.SourceKey, Value: slog.GroupValue(attrs...)}
+}
+
+// appendSourceGroup appends the group a, holding the source location,
+// among the built-in attributes, which are otherwise formatted
+// without a group prefix.
+func (s *handleState) appendSourceGroup(a slog.Attr) {
+	prefix := newBuffer()
+	defer prefix.Free()
+	s.prefix = prefix
+	if s.h.opts.ReplaceAttr != nil {
+		// So that ReplaceAttr sees the source group.
+		s.groups = new([]string)
 	}
-	s.buf.WriteByte('=')
+	s.appendAttr(a)
+	s.prefix = nil
+	s.groups = nil
 }
 
-func (s *handleState) appendSource(file string, line int) {
-	if needsQuoting(file) {
-		s.appendString(file + ":" + strconv.Itoa(line))
+// appendSource appends the source location. The function
+// name fn or the file may be empty.
+func (s *handleState) appendSource(fn, file string, line int) {
+	if s.needsQuoting(file) || fn != "" && s.needsQuoting(fn) {
+		s.appendString(sourceString(fn, file, line))
 	} else {
 		// common case: no quoting needed.
+		start := len(*s.buf)
+		s.buf.WriteString(fn)
+		if fn != "" && file != "" {
+			s.buf.WriteByte('@')
+		}
 		s.appendString(file)
 		s.buf.WriteByte(':')
 		s.buf.WritePosInt(line)
+		s.quoteSepFrom(start)
+	}
+}
+
+// appendKVSep appends the separator between a key and its value.
+func (s *handleState) appendKVSep() {
+	if sep := s.h.opts.KVSeparator; sep != "" {
+		s.buf.WriteString(sep)
+	} else {
+		s.buf.WriteByte('=')
 	}
 }
 
+// appendFieldSep appends the separator between attributes.
+func (s *handleState) appendFieldSep() {
+	if sep := s.h.opts.FieldSeparator; sep != "" {
+		s.buf.WriteString(sep)
+	} else {
+		s.buf.WriteByte(' ')
+	}
+}
+
+// needsQuoting reports whether the value str needs quoting
+// given the handler's options.
+func (s *handleState) needsQuoting(str string) bool {
+	if s.h.opts.AlwaysQuoteValues || str == "" && s.h.opts.QuoteEmpty {
+		return true
+	}
+	return s.quotingRequired(str, s.h.opts.ValueQuoteFunc)
+}
+
+// keyNeedsQuoting reports whether the key str needs quoting
+// given the handler's options.
+func (s *handleState) keyNeedsQuoting(str string) bool {
+	if s.h.opts.AlwaysQuoteKeys {
+		return true
+	}
+	return s.quotingRequired(str, s.h.opts.KeyQuoteFunc)
+}
+
+// quotingRequired reports whether str needs quoting, using f
+// if it is non-nil, or QuoteFunc or the default rules otherwise.
+func (s *handleState) quotingRequired(str string, f func(string) bool) bool {
+	if f == nil {
+		f = s.h.opts.QuoteFunc
+	}
+	if f != nil {
+		// Pass a copy so that str does not escape, which would
+		// cause allocations even when there is no QuoteFunc.
+		return f(strings.Clone(str))
+	}
+	if sep := s.h.opts.FieldSeparator; sep != "" && strings.TrimSpace(sep) != "" && strings.Contains(str, sep) {
+		return true
+	}
+	if q := s.h.opts.QuoteChar; q != 0 && q != '"' && strings.IndexByte(str, q) >= 0 {
+		return true
+	}
+	if sep := s.h.opts.KVSeparator; sep != "" && sep != "=" {
+		return needsQuotingSep(str, sep)
+	}
+	return needsQuoting(str)
+}
+
+// appendString appends the value str, quoting it if necessary.
 func (s *handleState) appendString(str string) {
-	if needsQuoting(str) {
-		*s.buf = strconv.AppendQuote(*s.buf, str)
+	if s.needsQuoting(str) {
+		*s.buf = s.appendQuoted(*s.buf, str)
+	} else {
+		s.buf.WriteString(str)
+	}
+}
+
+// appendKeyString appends the fully qualified key str,
+// quoting it if necessary.
+func (s *handleState) appendKeyString(str string) {
+	if s.keyNeedsQuoting(str) {
+		*s.buf = s.appendQuoted(*s.buf, str)
 	} else {
 		s.buf.WriteString(str)
 	}
 }
 
 func (s *handleState) appendValue(v slog.Value) {
+	start := len(*s.buf)
 	if err := appendTextValue(s, v); err != nil {
 		s.appendError(err)
 	}
+	s.truncateValueFrom(start)
+	s.quoteSepFrom(start)
+	s.quoteAllFrom(start)
 }
 
 func (s *handleState) appendTime(t time.Time) {
-	writeTimeRFC3339Millis(s.buf, t)
+	t = s.h.inTimeLocation(t)
+	switch {
+	case s.h.opts.AppendTime != nil:
+		start := len(*s.buf)
+		*s.buf = s.h.opts.AppendTime(*s.buf, t)
+		s.quoteFrom(start)
+	case s.h.opts.TimeLayout != "":
+		start := len(*s.buf)
+		*s.buf = t.AppendFormat(*s.buf, s.h.opts.TimeLayout)
+		s.quoteFrom(start)
+	case s.h.opts.TimeFormat != TimeRFC3339Millis:
+		start := len(*s.buf)
+		*s.buf = appendUnixTime(*s.buf, t, s.h.opts.TimeFormat)
+		s.quoteAllFrom(start)
+	default:
+		start := len(*s.buf)
+		writeTimeRFC3339Millis(s.buf, t)
+		if s.h.opts.QuoteFunc != nil || s.h.opts.ValueQuoteFunc != nil || s.h.opts.AlwaysQuoteValues {
+			s.quoteFrom(start)
+		} else {
+			s.quoteSepFrom(start)
+		}
+	}
+}
+
+// quoteSepFrom quotes the bytes in the buffer from start onwards
+// if they contain a KVSeparator other than "=" or a non-whitespace
+// FieldSeparator. This catches values, such as JSON, numbers or
+// times, that are written without a quoting check of their own.
+// Bytes that are already quoted are left alone.
+func (s *handleState) quoteSepFrom(start int) {
+	kvSep := s.h.opts.KVSeparator
+	if kvSep == "=" {
+		kvSep = ""
+	}
+	fieldSep := s.h.opts.FieldSeparator
+	if strings.TrimSpace(fieldSep) == "" {
+		fieldSep = ""
+	}
+	if kvSep == "" && fieldSep == "" {
+		return
+	}
+	b := (*s.buf)[start:]
+	if len(b) > 0 && b[0] == s.quoteChar() {
+		return
+	}
+	if kvSep != "" && strings.Contains(string(b), kvSep) || fieldSep != "" && strings.Contains(string(b), fieldSep) {
+		str := string(b)
+		*s.buf = s.appendQuoted((*s.buf)[:start], str)
+	}
+}
+
+// quoteAllFrom quotes the bytes in the buffer from start onwards
+// if the AlwaysQuoteValues option is set and they are not already
+// quoted. This catches values, such as numbers, that are written
+// without a quoting check of their own.
+func (s *handleState) quoteAllFrom(start int) {
+	if !s.h.opts.AlwaysQuoteValues {
+		return
+	}
+	if b := (*s.buf)[start:]; len(b) > 0 && b[0] == s.quoteChar() {
+		return
+	}
+	str := string((*s.buf)[start:])
+	*s.buf = s.appendQuoted((*s.buf)[:start], str)
+}
+
+// quoteFrom quotes the bytes in the buffer from start onwards
+// if they need quoting.
+func (s *handleState) quoteFrom(start int) {
+	if s.needsQuoting(string((*s.buf)[start:])) {
+		str := string((*s.buf)[start:])
+		*s.buf = s.appendQuoted((*s.buf)[:start], str)
+	}
+}
+
+// AppendRFC3339Millis appends t to buf in RFC 3339 format with
+// millisecond precision, as the handler writes times by default,
+// and returns the extended buffer. It does not allocate if buf
+// has enough capacity.
+func AppendRFC3339Millis(buf []byte, t time.Time) []byte {
+	b := buffer(buf)
+	writeTimeRFC3339Millis(&b, t)
+	return b
+}
+
+// appendUnixTime appends t to dst as an integer
+// number of units since the Unix epoch, as determined by f.
+func appendUnixTime(dst []byte, t time.Time, f TimeFormat) []byte {
+	var n int64
+	switch f {
+	case TimeUnixSeconds:
+		n = t.Unix()
+	case TimeUnixMillis:
+		n = t.UnixMilli()
+	default:
+		n = t.UnixNano()
+	}
+	return strconv.AppendInt(dst, n, 10)
 }
 
 // This takes half the time of Time.AppendFormat.
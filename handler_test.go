@@ -5,8 +5,11 @@ import (
 	"context"
 	"encoding/json"
 	"golang.org/x/exp/slog"
+	"io"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestHandler1(t *testing.T) {
@@ -154,7 +157,7 @@ func TestHandler1(t *testing.T) {
 		{
 			name:     "GroupValue as Attr value",
 			replace:  removeKeys(slog.TimeKey, slog.LevelKey),
-			attrs:    []slog.Attr{{"v", slog.AnyValue(slog.IntValue(3))}},
+			attrs:    []slog.Attr{{Key: "v", Value: slog.AnyValue(slog.IntValue(3))}},
 			wantText: "msg=message v=3",
 		},
 		{
@@ -167,7 +170,7 @@ func TestHandler1(t *testing.T) {
 			name:     "json.RawMessage",
 			replace:  removeKeys(slog.TimeKey, slog.LevelKey),
 			attrs:    []slog.Attr{slog.Any("bs", json.RawMessage([]byte("1234")))},
-			wantText: `msg=message bs="1234"`,
+			wantText: `msg=message bs=1234`,
 		},
 		{
 			name:    "inline group",
@@ -228,3 +231,347 @@ func (n logValueName) LogValue() slog.Value {
 		slog.String("first", n.first),
 		slog.String("last", n.last))
 }
+
+// rotatingWriter simulates a writer that switches to
+// a new file after every n calls to Write.
+type rotatingWriter struct {
+	n      int
+	writes int
+	files  []*bytes.Buffer
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	if w.writes%w.n == 0 {
+		w.files = append(w.files, new(bytes.Buffer))
+	}
+	w.writes++
+	return w.files[len(w.files)-1].Write(p)
+}
+
+func TestRotatingWriter(t *testing.T) {
+	w := &rotatingWriter{n: 3}
+	h := NewHandler(w)
+	if h.WriterFor(slog.LevelError) != io.Writer(w) {
+		t.Fatalf("unexpected writer from WriterFor")
+	}
+	handlers := []slog.Handler{
+		h,
+		h.WithAttrs([]slog.Attr{slog.String("with", "attrs")}),
+		h.WithGroup("g"),
+	}
+	const perHandler = 100
+	var wg sync.WaitGroup
+	for _, h := range handlers {
+		h := h
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perHandler; i++ {
+				r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+				r.AddAttrs(slog.Int("i", i), slog.String("s", strings.Repeat("x", i)))
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for i, f := range w.files {
+		lines := strings.SplitAfter(f.String(), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			t.Errorf("file %d ends with partial record %q", i, last)
+		}
+		lines = lines[:len(lines)-1]
+		for _, line := range lines {
+			if !strings.HasPrefix(line, "time=") {
+				t.Errorf("file %d has partial record %q", i, line)
+			}
+		}
+		total += len(lines)
+	}
+	if want := perHandler * len(handlers); total != want {
+		t.Errorf("got %d records, want %d", total, want)
+	}
+}
+
+func TestWithWriter(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	h1 := NewHandler(&buf1).WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("g").(*Handler)
+	h2 := h1.WithWriter(&buf2)
+	ctx := context.Background()
+	for i, h := range []slog.Handler{h1, h2, h1.WithAttrs([]slog.Attr{slog.Int("q", 2)})} {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("i", i))
+		if err := h.Handle(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := buf1.String(), "level=INFO msg=m p=1 g.i=0\nlevel=INFO msg=m p=1 g.q=2 g.i=2\n"; got != want {
+		t.Errorf("original handler: got %q, want %q", got, want)
+	}
+	if got, want := buf2.String(), "level=INFO msg=m p=1 g.i=1\n"; got != want {
+		t.Errorf("retargeted handler: got %q, want %q", got, want)
+	}
+	if h1.mu == h2.mu {
+		t.Errorf("retargeted handler shares lock with original")
+	}
+}
+
+func TestRecoverFromPanics(t *testing.T) {
+	replace := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == "bad" {
+			panic("boom")
+		}
+		return a
+	}
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions:    slog.HandlerOptions{ReplaceAttr: replace},
+		RecoverFromPanics: true,
+	})
+	r := slog.NewRecord(testTime, slog.LevelWarn, "a message", 0)
+	r.AddAttrs(slog.Int("good", 1), slog.Int("bad", 2))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `level=WARN msg="a message" error=!ERROR:panic:boom`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The line prefix and terminator are kept.
+	buf.Reset()
+	h2 := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions:    slog.HandlerOptions{ReplaceAttr: replace},
+		RecoverFromPanics: true,
+		LinePrefix:        "> ",
+		LineTerminator:    "\x00",
+	})
+	r = slog.NewRecord(testTime, slog.LevelWarn, "a message", 0)
+	r.AddAttrs(slog.Int("bad", 2))
+	if err := h2.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `> level=WARN msg="a message" error=!ERROR:panic:boom`+"\x00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Records that don't panic are unaffected.
+	buf.Reset()
+	r = slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("good", 1))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "time=2000-01-02T03:04:05.000Z level=INFO msg=m good=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Without the option, the panic propagates.
+	h = NewHandlerWithOpts(&buf, slog.HandlerOptions{ReplaceAttr: replace})
+	r = slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("bad", 2))
+	func() {
+		defer func() {
+			if v := recover(); v != "boom" {
+				t.Errorf("got panic value %v, want boom", v)
+			}
+		}()
+		h.Handle(context.Background(), r)
+	}()
+}
+
+// logValueString is a LogValuer that returns a scalar.
+type logValueString string
+
+func (s logValueString) LogValue() slog.Value {
+	return slog.StringValue("<" + string(s) + ">")
+}
+
+// logValueNested is a LogValuer that returns a group
+// holding another LogValuer.
+type logValueNested struct {
+	id   int
+	name logValueName
+}
+
+func (n logValueNested) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", n.id),
+		slog.Any("name", n.name),
+	)
+}
+
+// logValueChain is a LogValuer that returns another LogValuer.
+type logValueChain int
+
+func (c logValueChain) LogValue() slog.Value {
+	if c == 0 {
+		return slog.IntValue(0)
+	}
+	return slog.AnyValue(c - 1)
+}
+
+// logValueForever is a LogValuer that always returns another LogValuer.
+type logValueForever struct{}
+
+func (logValueForever) LogValue() slog.Value {
+	return slog.AnyValue(logValueForever{})
+}
+
+func TestMaxResolveDepth(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		attr  slog.Attr
+		depth int
+		want  string
+	}{
+		{"within limit", slog.Any("c", logValueChain(3)), 4, `c=0`},
+		{"beyond limit", slog.Any("c", logValueChain(3)), 3, `c="!ERROR:logvaluer depth exceeded"`},
+		{"forever", slog.Any("f", logValueForever{}), 10, `f="!ERROR:logvaluer depth exceeded"`},
+		{"not a LogValuer", slog.Int("n", 1), 1, `n=1`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, Options{MaxResolveDepth: test.depth})
+			// Attributes passed to WithAttrs are not resolved by slog.
+			h2 := h.WithAttrs([]slog.Attr{test.attr})
+			if err := h2.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLogValuer(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{"scalar", slog.Any("s", logValueString("x")), `s=<x>`},
+		{"group", slog.Any("name", logValueName{"Ren", "Hoek"}), `name.first=Ren name.last=Hoek`},
+		{"nested", slog.Any("n", logValueNested{1, logValueName{"Ren", "Hoek"}}), `n.id=1 n.name.first=Ren n.name.last=Hoek`},
+		{"chain", slog.Any("c", logValueChain(3)), `c=0`},
+		{"in group", slog.Group("g", slog.Any("s", logValueString("x")), slog.Any("name", logValueName{"a", "b"})), `g.s=<x> g.name.first=a g.name.last=b`},
+	} {
+		for _, replace := range []bool{false, true} {
+			for _, pre := range []bool{false, true} {
+				var buf bytes.Buffer
+				var opts slog.HandlerOptions
+				if replace {
+					opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+				}
+				var h slog.Handler = NewHandlerWithOpts(&buf, opts)
+				r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+				if pre {
+					h = h.WithAttrs([]slog.Attr{test.attr})
+				} else {
+					r.AddAttrs(test.attr)
+				}
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+					t.Errorf("%s, replace=%t, preformatted=%t: got %q, want %q", test.name, replace, pre, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLogValuerGroupMode(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// The attribute is created afresh for each use, because
+		// resolving a group may modify it in place.
+		attr func() slog.Attr
+		want [3]string // for GroupPrefixed, GroupInline and GroupJSON
+	}{{
+		"group",
+		func() slog.Attr { return slog.Any("name", logValueName{"Ren", "Hoek"}) },
+		[3]string{
+			`name.first=Ren name.last=Hoek`,
+			`first=Ren last=Hoek`,
+			`name={"first":"Ren","last":"Hoek"}`,
+		},
+	}, {
+		"nested",
+		func() slog.Attr { return slog.Any("n", logValueNested{1, logValueName{"Ren", "Hoek"}}) },
+		[3]string{
+			`n.id=1 n.name.first=Ren n.name.last=Hoek`,
+			`id=1 first=Ren last=Hoek`,
+			`n={"id":1,"name":{"first":"Ren","last":"Hoek"}}`,
+		},
+	}, {
+		"in group",
+		func() slog.Attr {
+			return slog.Group("g", slog.Any("s", logValueString("x")), slog.Any("name", logValueName{"a", "b"}))
+		},
+		[3]string{
+			`g.s=<x> g.name.first=a g.name.last=b`,
+			`g.s=<x> g.first=a g.last=b`,
+			`g.s=<x> g.name={"first":"a","last":"b"}`,
+		},
+	}, {
+		"plain group",
+		func() slog.Attr { return slog.Group("g", slog.Int("a", 1)) },
+		[3]string{`g.a=1`, `g.a=1`, `g.a=1`},
+	}} {
+		for mode, want := range test.want {
+			for _, replace := range []bool{false, true} {
+				var buf bytes.Buffer
+				opts := Options{LogValuerGroupMode: LogValuerGroupMode(mode)}
+				if replace {
+					opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+				}
+				h := NewHandlerWithOptions(&buf, opts).WithAttrs([]slog.Attr{test.attr()})
+				r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := buf.String(), "level=INFO msg=m "+want+"\n"; got != want {
+					t.Errorf("%s, mode=%d, replace=%t: got %q, want %q", test.name, mode, replace, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestAppendRFC3339Millis(t *testing.T) {
+	zones := []*time.Location{
+		time.UTC,
+		time.FixedZone("", 0),
+		time.FixedZone("EST", -5*3600),
+		time.FixedZone("IST", 5*3600+30*60),
+		time.FixedZone("", -(9*3600 + 30*60)),
+		time.FixedZone("", 14*3600),
+	}
+	times := []time.Time{
+		time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC),
+		time.Date(2023, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Date(1, 1, 1, 0, 0, 0, 1e6, time.UTC),
+		time.Date(2024, 2, 29, 12, 0, 0, 123456789, time.UTC),
+	}
+	for _, zone := range zones {
+		for _, tm := range times {
+			tm := tm.In(zone)
+			// Truncate the fractional seconds of RFC3339Nano to
+			// milliseconds, keeping any trailing zeros.
+			want := tm.Truncate(time.Millisecond).Format("2006-01-02T15:04:05.000Z07:00")
+			if nano := tm.Format(time.RFC3339Nano); want[:19] != nano[:19] {
+				t.Fatalf("bad test: %s vs %s", want, nano)
+			}
+			got := string(AppendRFC3339Millis([]byte("x"), tm))
+			if got != "x"+want {
+				t.Errorf("got %q, want %q", got, "x"+want)
+			}
+		}
+	}
+	buf := make([]byte, 0, 64)
+	tm := time.Now()
+	wantAllocs(t, 0, func() { AppendRFC3339Millis(buf, tm) })
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"strconv"
+
+	"golang.org/x/exp/slog"
+)
+
+// headerPrefix starts the line written by WriteHeader.
+const headerPrefix = "# slogtext v1"
+
+// WriteHeader writes a comment line identifying the format,
+// followed by the given field names if there are any, as in
+//
+//	# slogtext v1 fields: time level msg
+//
+// Field names are quoted if necessary. The line starts with the
+// LinePrefix and ends with the line terminator, as records do.
+// It is intended to be called
+// before any records are written, so that tools reading the output
+// can recognize it. Only the first call to WriteHeader on h or any
+// handler derived from it with WithAttrs or WithGroup writes the
+// header; later calls do nothing and return nil.
+func (h *Handler) WriteHeader(fields ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if *h.headerWritten {
+		return nil
+	}
+	*h.headerWritten = true
+	buf := newBuffer()
+	defer buf.Free()
+	buf.WriteString(h.opts.LinePrefix)
+	buf.WriteString(headerPrefix)
+	if len(fields) > 0 {
+		buf.WriteString(" fields:")
+		for _, f := range fields {
+			buf.WriteByte(' ')
+			if f == "" || needsQuoting(f) {
+				*buf = strconv.AppendQuote(*buf, f)
+			} else {
+				buf.WriteString(f)
+			}
+		}
+	}
+	h.appendLineTerminator(buf)
+	_, err := h.writeLocked(slog.LevelInfo, *buf)
+	return err
+}
@@ -0,0 +1,57 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestWriteHeader(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf)
+	if err := h.WriteHeader("time", "level", "msg", "a b"); err != nil {
+		t.Fatal(err)
+	}
+	h2 := h.WithGroup("g").(*Handler)
+	for _, h := range []*Handler{h, h2} {
+		if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.WriteHeader("time"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `# slogtext v1 fields: time level msg "a b"
+level=INFO msg=m
+level=INFO msg=m
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// A handler with a different writer writes its own header.
+	buf.Reset()
+	if err := h.WithWriter(&buf).WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "# slogtext v1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteHeaderLineTerminator(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{LinePrefix: "> ", LineTerminator: "\r\n"})
+	if err := h.WriteHeader("msg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "> # slogtext v1 fields: msg\r\n> level=INFO msg=m\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -7,26 +7,83 @@ package slogtext
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
 var nullBytes = []byte("null\n")
 
-func appendJSONMarshal(v any, dst []byte) ([]byte, error) {
+// jsonEncoder holds a json.Encoder together with the buffer
+// it writes to, so that both can be reused across values.
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonEncoderPool = sync.Pool{New: func() any {
+	e := new(jsonEncoder)
+	e.enc = json.NewEncoder(&e.buf)
 	// Use a json.Encoder to avoid escaping HTML.
-	var bb bytes.Buffer
-	enc := json.NewEncoder(&bb)
-	enc.SetEscapeHTML(false)
-	if err := enc.Encode(v); err != nil {
+	e.enc.SetEscapeHTML(false)
+	return e
+}}
+
+func newJSONEncoder() *jsonEncoder {
+	return jsonEncoderPool.Get().(*jsonEncoder)
+}
+
+func (e *jsonEncoder) free() {
+	// As with buffer.Free, don't retain large buffers.
+	const maxBufferSize = 16 << 10
+	if e.buf.Cap() <= maxBufferSize {
+		e.buf.Reset()
+		e.enc.SetIndent("", "")
+		jsonEncoderPool.Put(e)
+	}
+}
+
+// appendMarshal appends the JSON encoding of v to dst.
+func (e *jsonEncoder) appendMarshal(v any, dst []byte) ([]byte, error) {
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		var uerr *json.UnsupportedValueError
+		if errors.As(err, &uerr) {
+			if strings.HasPrefix(uerr.Str, "encountered a cycle") {
+				return nil, errJSONCycle
+			}
+			// For example, a NaN float inside a struct.
+			return nil, fmt.Errorf("cannot encode %s as JSON", uerr.Str)
+		}
 		return nil, err
 	}
-	bs := bb.Bytes()
+	bs := e.buf.Bytes()
 	if bytes.Equal(bs, nullBytes) {
 		return append(dst, "<nil>"...), nil
 	}
 	return append(dst, bs[:len(bs)-1]...), nil // remove final newline
 }
 
+// appendCanonical is like appendMarshal except that it sorts
+// the keys of all the objects in the encoding of v.
+func (e *jsonEncoder) appendCanonical(v any, dst []byte) ([]byte, error) {
+	data, err := e.appendMarshal(v, nil)
+	if err != nil || string(data) == "<nil>" {
+		return append(dst, data...), err
+	}
+	// Decoding into maps and encoding again sorts the keys.
+	// Numbers are decoded as json.Number, so they are unchanged.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var x any
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	return e.appendMarshal(x, dst)
+}
+
 // Copied from encoding/json/tables.go.
 //
 // safeSet holds the value true if the ASCII character with the given array
@@ -0,0 +1,137 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var errJSONCycle = errors.New("json cycle detected")
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// checkJSONDepth returns an error if x refers to itself or if
+// encoding it as JSON would nest objects and arrays more than
+// maxDepth deep. Values that marshal themselves are not examined.
+func checkJSONDepth(x any, maxDepth int) error {
+	c := depthChecker{
+		maxDepth: maxDepth,
+		seen:     make(map[any]bool),
+	}
+	return c.check(reflect.ValueOf(x), 0)
+}
+
+type depthChecker struct {
+	maxDepth int
+	// seen holds the pointers on the path to the current value.
+	seen map[any]bool
+}
+
+// ptrKey identifies the memory referred to by a pointer,
+// map or slice.
+type ptrKey struct {
+	ptr uintptr
+	len int
+	t   reflect.Type
+}
+
+func (c *depthChecker) check(v reflect.Value, depth int) error {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return c.check(v.Elem(), depth)
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return c.enter(ptrKey{v.Pointer(), 0, v.Type()}, func() error {
+			return c.check(v.Elem(), depth)
+		})
+	case reflect.Struct:
+		if err := c.nest(depth); err != nil {
+			return err
+		}
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if f := t.Field(i); !f.IsExported() && !f.Anonymous {
+				continue
+			}
+			if err := c.check(v.Field(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if err := c.nest(depth); err != nil {
+			return err
+		}
+		return c.enter(ptrKey{v.Pointer(), 0, v.Type()}, func() error {
+			iter := v.MapRange()
+			for iter.Next() {
+				if err := c.check(iter.Value(), depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case reflect.Slice:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as strings.
+			return nil
+		}
+		return c.enter(ptrKey{v.Pointer(), v.Len(), v.Type()}, func() error {
+			return c.checkElems(v, depth)
+		})
+	case reflect.Array:
+		return c.checkElems(v, depth)
+	}
+	return nil
+}
+
+func (c *depthChecker) checkElems(v reflect.Value, depth int) error {
+	if err := c.nest(depth); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := c.check(v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nest returns an error if an object or array at the given
+// depth would be too deep.
+func (c *depthChecker) nest(depth int) error {
+	if depth >= c.maxDepth {
+		return fmt.Errorf("json nesting deeper than %d", c.maxDepth)
+	}
+	return nil
+}
+
+// enter calls f with key recorded as being on the current path,
+// returning errJSONCycle if it is already there.
+func (c *depthChecker) enter(key ptrKey, f func() error) error {
+	if c.seen[key] {
+		return errJSONCycle
+	}
+	c.seen[key] = true
+	defer delete(c.seen, key)
+	return f()
+}
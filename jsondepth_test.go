@@ -0,0 +1,85 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+type jsonNode struct {
+	Name string
+	Next *jsonNode
+}
+
+type jsonTree struct {
+	Children []any
+}
+
+func TestMaxJSONDepth(t *testing.T) {
+	cyclic := &jsonNode{Name: "a"}
+	cyclic.Next = &jsonNode{Name: "b", Next: cyclic}
+	cyclicMap := map[string]any{"k": 1}
+	cyclicMap["self"] = cyclicMap
+	// deep is nested 10 deep: five structs each holding a slice.
+	var deep any = "leaf"
+	for i := 0; i < 5; i++ {
+		deep = jsonTree{Children: []any{deep}}
+	}
+	shared := &jsonNode{Name: "s"}
+	for _, test := range []struct {
+		name     string
+		maxDepth int
+		value    any
+		want     string
+	}{{
+		name:  "cycle without limit",
+		value: cyclic,
+		want:  `v="!ERROR:json cycle detected"`,
+	}, {
+		name:     "cycle",
+		maxDepth: 100,
+		value:    cyclic,
+		want:     `v="!ERROR:json cycle detected"`,
+	}, {
+		name:     "map cycle",
+		maxDepth: 100,
+		value:    cyclicMap,
+		want:     `v="!ERROR:json cycle detected"`,
+	}, {
+		name:     "shared but acyclic",
+		maxDepth: 100,
+		value:    []*jsonNode{shared, shared},
+		want:     `v=[{"Name":"s","Next":null},{"Name":"s","Next":null}]`,
+	}, {
+		name:     "deep within limit",
+		maxDepth: 10,
+		value:    deep,
+		want:     `v={"Children":[{"Children":[{"Children":[{"Children":[{"Children":["leaf"]}]}]}]}]}`,
+	}, {
+		name:     "too deep",
+		maxDepth: 9,
+		value:    deep,
+		want:     `v="!ERROR:json nesting deeper than 9"`,
+	}, {
+		name:     "marshaler not examined",
+		maxDepth: 1,
+		value:    map[string]time.Time{"t": testTime},
+		want:     `v={"t":"2000-01-02T03:04:05Z"}`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, Options{MaxJSONDepth: test.maxDepth})
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Any("v", test.value))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
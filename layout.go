@@ -0,0 +1,170 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"slices"
+
+	"golang.org/x/exp/slog"
+)
+
+// Layout determines the overall format of the records
+// written by a Handler.
+type Layout int
+
+const (
+	// LayoutText is the default key=value format
+	// described in [Handler.Handle].
+	LayoutText Layout = iota
+
+	// LayoutProtoText formats each record on a single line in
+	// a form resembling the protocol buffer text format:
+	//
+	//	time: "2000-01-02T03:04:05.000Z" level: "INFO" msg: "m" a: 1 g { b: "x" }
+	//
+	// Groups become nested blocks. Strings, times, durations and
+	// values of kind KindAny are written as quoted strings, escaped as
+	// in the protocol buffer text format, with octal escapes for bytes
+	// outside printable ASCII. Numbers and booleans are unquoted.
+	// Characters in keys that are not valid in field names
+	// are replaced by underscores.
+	LayoutProtoText
+
+	// LayoutXML formats each record on a single line as
+	// an XML element:
+	//
+	//	<record time="2000-01-02T03:04:05.000Z" level="INFO"><msg>m</msg><a>1</a><g><b>x</b></g></record>
+	//
+	// The built-in attributes other than the message become XML
+	// attributes of the record element, and the other attributes
+	// become child elements, with groups as nested elements. Text is
+	// escaped as XML. Characters in keys that are not valid in
+	// XML names are replaced by underscores.
+	LayoutXML
+)
+
+// layoutAttrs returns the built-in attributes of r and its other
+// attributes, for use by layouts other than LayoutText. The attributes
+// are resolved, ReplaceAttr has been applied, and groups started with
+// WithGroup are represented as nested group attributes.
+func (h *Handler) layoutAttrs(ctx context.Context, r slog.Record, rv recordValues) (builtins, attrs []slog.Attr) {
+	builtinRep := &h.opts.ReplaceBuiltins
+	if !r.Time.IsZero() {
+		t := h.inTimeLocation(r.Time.Round(0))
+		if builtinRep.Time != nil {
+			t = builtinRep.Time(t)
+		}
+		if !t.IsZero() {
+			builtins = append(builtins, slog.Time(slog.TimeKey, t))
+		}
+	}
+	if builtinRep.Level != nil {
+		if label := builtinRep.Level(r.Level); label != "" {
+			builtins = append(builtins, slog.String(slog.LevelKey, label))
+		}
+	} else {
+		builtins = append(builtins, slog.Any(slog.LevelKey, r.Level))
+	}
+	if h.addsSource(r.Level) {
+		if f := recordFrame(r); f.File != "" && h.opts.SourceAsGroup {
+			builtins = append(builtins, h.sourceGroup(f))
+		} else if f.File != "" {
+			fn, file := h.sourceParts(f)
+			src := sourceString(fn, file, f.Line)
+			if builtinRep.Source != nil {
+				src = builtinRep.Source(src)
+			}
+			if src != "" {
+				builtins = append(builtins, slog.String(slog.SourceKey, src))
+			}
+		}
+	}
+	msg := r.Message
+	if builtinRep.Message != nil {
+		msg = builtinRep.Message(msg)
+	}
+	builtins = append(builtins, slog.String(slog.MessageKey, msg))
+	if rv.seq != 0 {
+		builtins = append(builtins, slog.Uint64(SequenceKey, rv.seq))
+	}
+	if h.opts.AddRequestID {
+		if id, ok := RequestIDFromContext(ctx); ok {
+			builtins = append(builtins, slog.String(RequestIDKey, id))
+		}
+	}
+	if h.opts.AddMonotonic {
+		builtins = append(builtins, slog.Int64(MonotonicKey, rv.mono))
+	}
+	for _, rule := range h.opts.TagRules {
+		if rule.Match(r) {
+			builtins = append(builtins, slog.String(TagKey, rule.Tag))
+		}
+	}
+	if h.hostname != "" {
+		builtins = append(builtins, slog.String(HostKey, h.hostname))
+	}
+	if h.pid != 0 {
+		builtins = append(builtins, slog.Int(PIDKey, h.pid))
+	}
+	attrs = append(attrs, h.contextAttrs(ctx)...)
+	r.Attrs(func(a slog.Attr) {
+		attrs = append(attrs, a)
+	})
+	attrs = nestAttrs(h.goas, attrs)
+	rep := h.opts.ReplaceAttr
+	return replaceAttrs(rep, nil, builtins), replaceAttrs(rep, nil, attrs)
+}
+
+// nestAttrs returns attrs inside the groups and after the
+// attributes recorded in goas.
+func nestAttrs(goas []groupOrAttrs, attrs []slog.Attr) []slog.Attr {
+	// Apply the groups and attributes from the innermost outwards.
+	for i := len(goas) - 1; i >= 0; i-- {
+		goa := goas[i]
+		if goa.group != "" {
+			if len(attrs) > 0 {
+				attrs = []slog.Attr{{Key: goa.group, Value: slog.GroupValue(attrs...)}}
+			}
+		} else {
+			attrs = append(slices.Clip(goa.attrs), attrs...)
+		}
+	}
+	return attrs
+}
+
+// replaceAttrs returns attrs with their values resolved and rep (if
+// non-nil) applied, following the same rules as the text layout: empty
+// groups and attributes with empty keys are omitted, and groups with
+// empty keys are inlined.
+func replaceAttrs(rep func([]string, slog.Attr) slog.Attr, groups []string, attrs []slog.Attr) []slog.Attr {
+	result := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Key == "" && a.Value.Kind() != slog.KindGroup {
+			continue
+		}
+		if rep != nil && a.Value.Kind() != slog.KindGroup {
+			a = rep(groups, a)
+			if a.Key == "" {
+				continue
+			}
+			a.Value = a.Value.Resolve()
+		}
+		if a.Value.Kind() != slog.KindGroup {
+			result = append(result, a)
+			continue
+		}
+		if a.Key == "" {
+			result = append(result, replaceAttrs(rep, groups, a.Value.Group())...)
+			continue
+		}
+		members := replaceAttrs(rep, append(slices.Clip(groups), a.Key), a.Value.Group())
+		if len(members) > 0 {
+			result = append(result, slog.Attr{Key: a.Key, Value: slog.GroupValue(members...)})
+		}
+	}
+	return result
+}
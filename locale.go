@@ -0,0 +1,25 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "golang.org/x/exp/slog"
+
+// appendLocalNumber appends v, quoted, using the handler's locale
+// if it is a number, and reports whether it did so.
+func (s *handleState) appendLocalNumber(v slog.Value) bool {
+	var str string
+	switch v.Kind() {
+	case slog.KindInt64:
+		str = s.h.printer.Sprint(v.Int64())
+	case slog.KindUint64:
+		str = s.h.printer.Sprint(v.Uint64())
+	case slog.KindFloat64:
+		str = s.h.printer.Sprint(v.Float64())
+	default:
+		return false
+	}
+	*s.buf = s.appendQuoted(*s.buf, str)
+	return true
+}
@@ -0,0 +1,39 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/text/language"
+)
+
+func TestLocale(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.Float64("f", 1234.56),
+		slog.Int("i", -1234567),
+		slog.Uint64("u", 12),
+		slog.String("s", "1234.56"),
+	}
+	for _, test := range []struct {
+		locale language.Tag
+		want   string
+	}{
+		{language.Und, `f=1234.56 i=-1234567 u=12 s=1234.56`},
+		{language.AmericanEnglish, `f="1,234.56" i="-1,234,567" u="12" s=1234.56`},
+		{language.German, `f="1.234,56" i="-1.234.567" u="12" s=1234.56`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{Locale: test.locale})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("%v: got %q, want %q", test.locale, got, want)
+		}
+	}
+}
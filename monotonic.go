@@ -0,0 +1,22 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "time"
+
+// MonotonicKey is the key used by the handler for the monotonic
+// clock reading when [Options.AddMonotonic] is set.
+const MonotonicKey = "mono"
+
+// monotonicBase is the origin of the monotonic clock readings.
+// Because it holds a monotonic clock reading, time.Since(monotonicBase)
+// is unaffected by changes to the wall clock.
+var monotonicBase = time.Now()
+
+// monotonicNanos returns the number of nanoseconds elapsed
+// on the monotonic clock since the package was initialized.
+func monotonicNanos() int64 {
+	return int64(time.Since(monotonicBase))
+}
@@ -0,0 +1,43 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestAddMonotonic(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{AddMonotonic: true})
+	for i := 0; i < 100; i++ {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("i", i))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := int64(-1)
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		rest, ok := strings.CutPrefix(line, "level=INFO msg=m mono=")
+		if !ok {
+			t.Fatalf("unexpected line %q", line)
+		}
+		monoStr, iStr, ok := strings.Cut(rest, " ")
+		if !ok || iStr != "i="+strconv.Itoa(i) {
+			t.Fatalf("unexpected line %q", line)
+		}
+		mono, err := strconv.ParseInt(monoStr, 10, 64)
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if mono < last {
+			t.Errorf("line %d: mono decreased from %d to %d", i, last, mono)
+		}
+		last = mono
+	}
+}
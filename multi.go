@@ -0,0 +1,109 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/exp/slog"
+)
+
+// multiHandler is the handler returned by MultiHandler.
+type multiHandler []slog.Handler
+
+// MultiHandler returns a handler that passes each record to all the
+// given handlers that are enabled for its level, so that, for example,
+// the same records can be written as text to one file and as JSON
+// to another. Handle returns the errors from all the handlers
+// joined with [errors.Join].
+//
+// The Enabled method of each handler is called exactly once per
+// record, by Handle, so that handlers whose Enabled method has side
+// effects, such as those using [Options.Sample] or returned by
+// [NewSampledHandler], make one decision per record.
+func MultiHandler(handlers ...slog.Handler) slog.Handler {
+	return multiHandler(handlers)
+}
+
+// Enabled implements [slog.Handler.Enabled] by reporting whether
+// any of the handlers might be enabled. To avoid consuming
+// sampling decisions, it consults only the level of the handlers in
+// this package whose Enabled methods have side effects; it calls
+// the Enabled methods of other handlers, which by convention
+// have none.
+func (h multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h1 := range h {
+		if levelEnabled(h1, ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// levelEnabler is implemented by the handlers in this package
+// whose Enabled method may have side effects.
+type levelEnabler interface {
+	// levelEnabled is like Enabled except that it
+	// has no side effects: it checks only the level,
+	// and reports true for records that Enabled might
+	// reject for other reasons.
+	levelEnabled(ctx context.Context, level slog.Level) bool
+}
+
+// levelEnabled calls h.levelEnabled if h implements levelEnabler.
+// Otherwise it calls h.Enabled.
+func levelEnabled(h slog.Handler, ctx context.Context, level slog.Level) bool {
+	if h, ok := h.(levelEnabler); ok {
+		return h.levelEnabled(ctx, level)
+	}
+	return h.Enabled(ctx, level)
+}
+
+func (h *Handler) levelEnabled(ctx context.Context, level slog.Level) bool {
+	return h.enabled(ctx, level)
+}
+
+func (h *AsyncHandler) levelEnabled(ctx context.Context, level slog.Level) bool {
+	return h.h.enabled(ctx, level)
+}
+
+func (h *sampledHandler) levelEnabled(ctx context.Context, level slog.Level) bool {
+	return levelEnabled(h.h, ctx, level)
+}
+
+// WithAttrs implements [slog.Handler.WithAttrs].
+func (h multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := make(multiHandler, len(h))
+	for i, h1 := range h {
+		h2[i] = h1.WithAttrs(attrs)
+	}
+	return h2
+}
+
+// WithGroup implements [slog.Handler.WithGroup].
+func (h multiHandler) WithGroup(name string) slog.Handler {
+	h2 := make(multiHandler, len(h))
+	for i, h1 := range h {
+		h2[i] = h1.WithGroup(name)
+	}
+	return h2
+}
+
+// Handle implements [slog.Handler.Handle].
+func (h multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h1 := range h {
+		if !h1.Enabled(ctx, r.Level) {
+			continue
+		}
+		// Each handler gets its own copy in case it
+		// adds attributes to the record.
+		if err := h1.Handle(ctx, r.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/time/rate"
+)
+
+func TestMultiHandler(t *testing.T) {
+	var textBuf, jsonBuf, debugBuf bytes.Buffer
+	h := MultiHandler(
+		NewHandler(&textBuf),
+		slog.HandlerOptions{ReplaceAttr: removeKeys(slog.TimeKey)}.NewJSONHandler(&jsonBuf),
+		NewHandlerWithOpts(&debugBuf, slog.HandlerOptions{Level: slog.LevelDebug}),
+	)
+	l := slog.New(h.WithAttrs([]slog.Attr{slog.Int("a", 1)}).WithGroup("g"))
+	if !h.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("not enabled for DEBUG")
+	}
+	l.Info("hello", "b", 2)
+	l.Debug("detail")
+	if got, want := removeTimes(textBuf.String()), "level=INFO msg=hello a=1 g.b=2\n"; got != want {
+		t.Errorf("text: got %q, want %q", got, want)
+	}
+	if got, want := removeTimes(debugBuf.String()), "level=INFO msg=hello a=1 g.b=2\nlevel=DEBUG msg=detail a=1\n"; got != want {
+		t.Errorf("debug: got %q, want %q", got, want)
+	}
+	if got, want := jsonBuf.String(), `{"level":"INFO","msg":"hello","a":1,"g":{"b":2}}`+"\n"; got != want {
+		t.Errorf("JSON: got %q, want %q", got, want)
+	}
+}
+
+func TestMultiHandlerErrors(t *testing.T) {
+	err1 := errors.New("first")
+	err2 := errors.New("second")
+	var buf bytes.Buffer
+	h := MultiHandler(
+		NewHandler(errorWriter{err1}),
+		NewHandler(&buf),
+		NewHandler(errorWriter{err2}),
+	)
+	err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Errorf("got error %v, want both errors", err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := MultiHandler(NewHandler(&buf)).Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
+
+func TestMultiHandlerSampling(t *testing.T) {
+	newSampled := func(w *bytes.Buffer) *Handler {
+		return NewHandlerWithOptions(w, Options{
+			Sample: SampleEvery(map[slog.Level]int{slog.LevelInfo: 2}),
+		})
+	}
+	var aloneBuf, sampledBuf, plainBuf, rateBuf bytes.Buffer
+	alone := slog.New(newSampled(&aloneBuf))
+	now := testTime
+	multi := slog.New(MultiHandler(
+		newSampled(&sampledBuf),
+		NewHandler(&plainBuf),
+		newSampledHandler(NewHandler(&rateBuf), map[slog.Level]rate.Limit{
+			slog.LevelInfo: 3,
+		}, func() time.Time { return now }),
+	))
+	for i := 0; i < 10; i++ {
+		alone.Info("m")
+		multi.Info("m")
+	}
+	count := func(buf *bytes.Buffer) int {
+		return strings.Count(buf.String(), "\n")
+	}
+	if got, want := count(&sampledBuf), count(&aloneBuf); got != want || got != 5 {
+		t.Errorf("sampled: got %d records, want %d (as without MultiHandler)", got, want)
+	}
+	if got, want := count(&plainBuf), 10; got != want {
+		t.Errorf("plain: got %d records, want %d", got, want)
+	}
+	if got, want := count(&rateBuf), 3; got != want {
+		t.Errorf("rate-limited: got %d records, want %d", got, want)
+	}
+	// Enabled consumes no sampling decisions.
+	h := newSampled(&sampledBuf)
+	m := MultiHandler(h)
+	for i := 0; i < 3; i++ {
+		if !m.Enabled(context.Background(), slog.LevelInfo) {
+			t.Errorf("not enabled for INFO")
+		}
+	}
+	if !h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Errorf("first record sampled out after calls to MultiHandler.Enabled")
+	}
+	if m.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("enabled for DEBUG")
+	}
+}
+
+func TestMultiHandlerEnabledForeign(t *testing.T) {
+	var buf bytes.Buffer
+	m := MultiHandler(slog.HandlerOptions{Level: slog.LevelWarn}.NewJSONHandler(&buf))
+	if m.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("enabled for DEBUG with a WARN child")
+	}
+	if !m.Enabled(context.Background(), slog.LevelWarn) {
+		t.Errorf("not enabled for WARN with a WARN child")
+	}
+}
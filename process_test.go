@@ -0,0 +1,83 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestIncludeHostnamePID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("cannot get host name: %v", err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{{
+		name: "both",
+		opts: Options{IncludeHostname: true, IncludePID: true},
+		want: `level=INFO msg=m host=` + quoteIfNeeded(hostname) + ` pid=` + pid + ` a=1`,
+	}, {
+		name: "pid",
+		opts: Options{IncludePID: true},
+		want: `level=INFO msg=m pid=` + pid + ` a=1`,
+	}, {
+		name: "rename",
+		opts: Options{
+			HandlerOptions: slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == PIDKey && len(groups) == 0 {
+						a.Key = "process"
+					}
+					return a
+				},
+			},
+			IncludePID: true,
+		},
+		want: `level=INFO msg=m process=` + pid + ` a=1`,
+	}, {
+		name: "remove",
+		opts: Options{
+			HandlerOptions: slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == HostKey && len(groups) == 0 {
+						return slog.Attr{}
+					}
+					return a
+				},
+			},
+			IncludeHostname: true,
+			IncludePID:      true,
+		},
+		want: `level=INFO msg=m pid=` + pid + ` a=1`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Int("a", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// quoteIfNeeded quotes s as the handler would.
+func quoteIfNeeded(s string) string {
+	if needsQuoting(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"encoding"
+	"strconv"
+
+	"golang.org/x/exp/slog"
+)
+
+// appendProtoText appends attrs to buf in the format described
+// by LayoutProtoText. Values that cannot be formatted are
+// written as errors preceded by errPrefix.
+func appendProtoText(buf *buffer, attrs []slog.Attr, errPrefix string) {
+	for i, a := range attrs {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		appendProtoFieldName(buf, a.Key)
+		if a.Value.Kind() == slog.KindGroup {
+			buf.WriteString(" { ")
+			appendProtoText(buf, a.Value.Group(), errPrefix)
+			buf.WriteString(" }")
+			continue
+		}
+		buf.WriteString(": ")
+		appendProtoValue(buf, a.Value, errPrefix)
+	}
+}
+
+func appendProtoValue(buf *buffer, v slog.Value, errPrefix string) {
+	switch v.Kind() {
+	case slog.KindInt64:
+		*buf = strconv.AppendInt(*buf, v.Int64(), 10)
+	case slog.KindUint64:
+		*buf = strconv.AppendUint(*buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		*buf = strconv.AppendFloat(*buf, v.Float64(), 'g', -1, 64)
+	case slog.KindBool:
+		*buf = strconv.AppendBool(*buf, v.Bool())
+	case slog.KindTime:
+		var tb buffer
+		writeTimeRFC3339Millis(&tb, v.Time())
+		appendProtoString(buf, string(tb))
+	case slog.KindAny, slog.KindLogValuer:
+		appendProtoString(buf, anyString(v.Any(), errPrefix))
+	default:
+		// Strings and durations.
+		appendProtoString(buf, v.String())
+	}
+}
+
+// anyString returns a string form of x for layouts that
+// write all values of kind KindAny as strings. If x cannot
+// be formatted, it returns the error preceded by errPrefix.
+func anyString(x any, errPrefix string) string {
+	switch x := x.(type) {
+	case encoding.TextMarshaler:
+		data, err := x.MarshalText()
+		if err != nil {
+			return errPrefix + err.Error()
+		}
+		return string(data)
+	case error:
+		return x.Error()
+	}
+	if bs, ok := byteSlice(x); ok {
+		return string(bs)
+	}
+	e := newJSONEncoder()
+	defer e.free()
+	data, err := e.appendMarshal(x, nil)
+	if err != nil {
+		return errPrefix + err.Error()
+	}
+	return string(data)
+}
+
+// appendProtoFieldName appends key to buf as a valid field name,
+// replacing invalid characters with underscores.
+func appendProtoFieldName(buf *buffer, key string) {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			c = '_'
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// appendProtoString appends s to buf as a quoted string using
+// the escapes of the protocol buffer text format.
+func appendProtoString(buf *buffer, s string) {
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '\\':
+			buf.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				buf.WriteByte('\\')
+				buf.WriteByte('0' + c>>6)
+				buf.WriteByte('0' + c>>3&7)
+				buf.WriteByte('0' + c&7)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,83 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestLayoutProtoText(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  slog.HandlerOptions
+		with  func(slog.Handler) slog.Handler
+		attrs []slog.Attr
+		want  string
+	}{
+		{
+			name: "scalars",
+			attrs: []slog.Attr{
+				slog.Int("a", 1),
+				slog.Float64("f", 1.5),
+				slog.Bool("b", true),
+				slog.Duration("d", time.Second),
+				slog.String("s", "x\ty\"é\x01"),
+				slog.Any("j", map[string]int{"k": 1}),
+			},
+			want: `time: "2000-01-02T03:04:05.000Z" level: "INFO" msg: "m" a: 1 f: 1.5 b: true d: "1s" s: "x\ty\"\303\251\001" j: "{\"k\":1}"`,
+		},
+		{
+			name: "nested groups",
+			with: func(h slog.Handler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("s")
+			},
+			attrs: []slog.Attr{
+				slog.Group("g", slog.Int("a", 1), slog.Group("h", slog.String("b", "x"))),
+				slog.Group("empty"),
+				slog.Any("name", logValueName{"Ren", "Hoek"}),
+				slog.Int("bad key", 2),
+			},
+			want: `time: "2000-01-02T03:04:05.000Z" level: "INFO" msg: "m" p: 1 s { g { a: 1 h { b: "x" } } name { first: "Ren" last: "Hoek" } bad_key: 2 }`,
+		},
+		{
+			name: "ReplaceAttr",
+			opts: slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				if len(groups) > 0 {
+					a.Key = strings.Join(groups, "_") + "_" + a.Key
+				}
+				return a
+			}},
+			with: func(h slog.Handler) slog.Handler {
+				return h.WithGroup("s")
+			},
+			attrs: []slog.Attr{slog.Group("g", slog.Int("a", 1))},
+			want:  `level: "INFO" msg: "m" s { g { s_g_a: 1 } }`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, Options{
+				HandlerOptions: test.opts,
+				Layout:         LayoutProtoText,
+			})
+			if test.with != nil {
+				h = test.with(h)
+			}
+			r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// quoteChar returns the character used to quote keys and values.
+func (s *handleState) quoteChar() byte {
+	if q := s.h.opts.QuoteChar; q != 0 {
+		return q
+	}
+	return '"'
+}
+
+// appendQuoted appends str to dst, quoted with the handler's
+// quote character.
+func (s *handleState) appendQuoted(dst []byte, str string) []byte {
+	return appendQuoteWith(dst, str, s.quoteChar())
+}
+
+// appendQuoteWith appends str to dst quoted as by [strconv.Quote] but
+// with q in place of the double quote: q is escaped with a backslash
+// and a double quote is not escaped.
+func appendQuoteWith(dst []byte, str string, q byte) []byte {
+	if q == '"' {
+		return strconv.AppendQuote(dst, str)
+	}
+	start := len(dst)
+	dst = strconv.AppendQuote(dst, str)
+	// Rewrite the escaped body in place; it can grow by one byte
+	// for each occurrence of q.
+	body := strings.Clone(string(dst[start+1 : len(dst)-1]))
+	dst = append(dst[:start], q)
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && body[i+1] == '"':
+			dst = append(dst, '"')
+			i++
+		case c == '\\':
+			// Copy the escape's first character so that, for example,
+			// the q in \q (never produced above) or x in \x27 is
+			// not treated as special.
+			dst = append(dst, c, body[i+1])
+			i++
+		case c == q:
+			dst = append(dst, '\\', q)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, q)
+}
+
+// unquoteWith interprets str as a string quoted by appendQuoteWith
+// with the quote character q, returning the string's value.
+func unquoteWith(str string, q byte) (string, error) {
+	if q == '"' {
+		return strconv.Unquote(str)
+	}
+	if len(str) < 2 || str[0] != q || str[len(str)-1] != q {
+		return "", errors.New("invalid quoted string")
+	}
+	body := str[1 : len(str)-1]
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body) && body[i+1] == q:
+			b.WriteByte(q)
+			i++
+		case c == '\\' && i+1 < len(body):
+			b.WriteByte(c)
+			b.WriteByte(body[i+1])
+			i++
+		case c == '"':
+			b.WriteString(`\"`)
+		case c == q:
+			return "", errors.New("unescaped quote character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return strconv.Unquote(b.String())
+}
@@ -0,0 +1,144 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestQuoteChar(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{QuoteChar: '\''})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "it's", 0)
+	r.AddAttrs(
+		slog.String("a", `say "hi"`),
+		slog.String("b", `back\slash`),
+		slog.String("c", "tab\tand 'quotes'"),
+		slog.String("d's", "x"),
+		slog.Any("e", []byte("\x01'")),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.TrimSuffix(buf.String(), "\n")
+	want := `level=INFO msg='it\'s' a='say "hi"' b=back\slash c='tab\tand \'quotes\'' 'd\'s'=x e='\x01\''`
+	if got != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	// Check that the quoted strings round trip.
+	for _, test := range []struct {
+		quoted, want string
+	}{
+		{`'it\'s'`, "it's"},
+		{`'say "hi"'`, `say "hi"`},
+		{`'tab\tand \'quotes\''`, "tab\tand 'quotes'"},
+		{`'d\'s'`, "d's"},
+		{`'\x01\''`, "\x01'"},
+	} {
+		s, err := unquoteWith(test.quoted, '\'')
+		if err != nil {
+			t.Errorf("unquoteWith(%s): %v", test.quoted, err)
+		} else if s != test.want {
+			t.Errorf("unquoteWith(%s) = %q, want %q", test.quoted, s, test.want)
+		}
+	}
+}
+
+func TestQuoteCharNonFinite(t *testing.T) {
+	for _, test := range []struct {
+		opts Options
+		want string
+	}{{
+		opts: Options{QuoteChar: '\''},
+		want: `level=INFO msg=m nan='NaN' pinf='+Inf' ninf='-Inf'`,
+	}, {
+		opts: Options{QuoteChar: '\'', AlwaysQuoteValues: true},
+		want: `level='INFO' msg='m' nan='NaN' pinf='+Inf' ninf='-Inf'`,
+	}, {
+		opts: Options{AlwaysQuoteValues: true},
+		want: `level="INFO" msg="m" nan="NaN" pinf="+Inf" ninf="-Inf"`,
+	}, {
+		opts: Options{QuoteChar: '\'', NonFiniteFloat: NonFiniteNull},
+		want: `level=INFO msg=m nan=null pinf=null ninf=null`,
+	}} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, test.opts)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(
+			slog.Float64("nan", math.NaN()),
+			slog.Float64("pinf", math.Inf(1)),
+			slog.Float64("ninf", math.Inf(-1)),
+		)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != test.want {
+			t.Errorf("%+v:\ngot  %s\nwant %s", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestQuoteWithRoundTrip(t *testing.T) {
+	for _, q := range []byte{'"', '\'', '`', '|'} {
+		for _, s := range []string{"", "a", `'"`, `\'`, "\\\x00\n", "é ", "|`'\"\\"} {
+			quoted := string(appendQuoteWith(nil, s, q))
+			got, err := unquoteWith(quoted, q)
+			if err != nil || got != s {
+				t.Errorf("quote %c: %q quoted as %s unquotes to %q, %v", q, s, quoted, got, err)
+			}
+		}
+	}
+	if _, err := unquoteWith(`'a'b'`, '\''); err == nil {
+		t.Errorf("expected error for unescaped quote")
+	}
+}
+
+func TestQuoteCharInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{QuoteChar: 'a'},
+		{QuoteChar: '\\'},
+		{QuoteChar: '='},
+		{QuoteChar: ' '},
+		{QuoteChar: '|', FieldSeparator: " | "},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic for QuoteChar %q", opts.QuoteChar)
+				}
+			}()
+			NewHandlerWithOptions(&bytes.Buffer{}, opts)
+		}()
+	}
+}
+
+func TestQuoteEmpty(t *testing.T) {
+	for _, test := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, `level=INFO msg=m p= a= b=x g.c= g.d=0`},
+		{Options{QuoteEmpty: true}, `level=INFO msg=m p="" a="" b=x g.c="" g.d=0`},
+		{Options{QuoteEmpty: true, QuoteChar: '\''}, `level=INFO msg=m p='' a='' b=x g.c='' g.d=0`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, test.opts).WithAttrs([]slog.Attr{slog.String("p", "")})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(
+			slog.String("a", ""),
+			slog.String("b", "x"),
+			slog.Group("g", slog.String("c", ""), slog.Int("d", 0)),
+		)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != test.want {
+			t.Errorf("%+v:\ngot  %s\nwant %s", test.opts, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// writeRetrying writes p to w, retrying failed writes as
+// determined by the WriteRetries, WriteBackoff and RetryableError
+// options. It is called with h.mu held.
+func (h *Handler) writeRetrying(w io.Writer, p []byte) (int, error) {
+	written := 0
+	backoff := h.opts.WriteBackoff
+	for retry := 0; ; retry++ {
+		n, err := h.writeOnce(w, p[written:])
+		written += n
+		if err == nil || retry >= h.opts.WriteRetries || !h.retryable(err) {
+			return written, err
+		}
+		if backoff > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// retryable reports whether a write that failed with err
+// should be retried.
+func (h *Handler) retryable(err error) bool {
+	if h.opts.RetryableError != nil {
+		return h.opts.RetryableError(err)
+	}
+	var terr interface{ Timeout() bool }
+	return errors.As(err, &terr) && terr.Timeout()
+}
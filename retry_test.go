@@ -0,0 +1,101 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// timeoutError is a transient error as reported by network connections.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// flakyWriter fails the first failures calls to Write,
+// writing at most partial bytes in each of them.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+	partial  int
+	err      error
+	calls    int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.calls++
+	if w.calls <= w.failures {
+		n := min(w.partial, len(p))
+		w.Buffer.Write(p[:n])
+		return n, w.err
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWriteRetries(t *testing.T) {
+	w := &flakyWriter{failures: 2, partial: 5, err: timeoutError{}}
+	h := NewHandlerWithOptions(w, Options{
+		WriteRetries: 3,
+		WriteBackoff: time.Millisecond,
+	})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "hello", 0)
+	n, err := h.HandleN(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "level=INFO msg=hello\n"
+	if got := w.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n != len(want) {
+		t.Errorf("got %d bytes, want %d", n, len(want))
+	}
+	if w.calls != 3 {
+		t.Errorf("got %d calls to Write, want 3", w.calls)
+	}
+}
+
+func TestWriteRetriesExhausted(t *testing.T) {
+	w := &flakyWriter{failures: 3, err: timeoutError{}}
+	h := NewHandlerWithOptions(w, Options{WriteRetries: 2})
+	err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "hello", 0))
+	if err != (timeoutError{}) {
+		t.Errorf("got error %v, want %v", err, timeoutError{})
+	}
+	if w.calls != 3 {
+		t.Errorf("got %d calls to Write, want 3", w.calls)
+	}
+}
+
+func TestWriteRetriesNotRetryable(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	w := &flakyWriter{failures: 1, err: errPermanent}
+	h := NewHandlerWithOptions(w, Options{WriteRetries: 2})
+	err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "hello", 0))
+	if err != errPermanent {
+		t.Errorf("got error %v, want %v", err, errPermanent)
+	}
+	if w.calls != 1 {
+		t.Errorf("got %d calls to Write, want 1", w.calls)
+	}
+
+	// A custom predicate can allow other errors to be retried.
+	w = &flakyWriter{failures: 1, err: errPermanent}
+	h = NewHandlerWithOptions(w, Options{
+		WriteRetries: 2,
+		RetryableError: func(err error) bool {
+			return err == errPermanent
+		},
+	})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "hello", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if w.calls != 2 {
+		t.Errorf("got %d calls to Write, want 2", w.calls)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/time/rate"
+)
+
+// sampledSummaryInterval is the minimum interval between
+// the summaries of dropped records written by a sampled handler.
+const sampledSummaryInterval = time.Second
+
+// sampledHandler is the handler returned by NewSampledHandler.
+type sampledHandler struct {
+	h slog.Handler
+	s *sampledState // shared by all derived handlers
+}
+
+// sampledState holds the limiters for a sampled handler.
+type sampledState struct {
+	// base is the handler passed to NewSampledHandler,
+	// used to write the summaries.
+	base     slog.Handler
+	now      func() time.Time
+	limiters map[slog.Level]*rate.Limiter
+	dropped  map[slog.Level]*atomic.Int64
+
+	mu          sync.Mutex
+	lastSummary time.Time
+}
+
+// NewSampledHandler returns a handler that passes records to h
+// unless there have been too many recently at the same level, in
+// which case it drops them. For each level in perLevel, records at
+// exactly that level are limited to the given rate, with bursts of
+// up to the rate's number of records per second; records at other
+// levels are not limited, and neither are records at a level
+// whose limit is [rate.Inf]. NewSampledHandler panics if a limit
+// is negative or NaN.
+//
+// When records have been dropped, the handler passes a summary
+// record with the message "N records dropped" to h, at the level of
+// the dropped records, before the next record it handles, at most
+// once a second. The summary does not include any attributes or
+// groups added with WithAttrs or WithGroup.
+func NewSampledHandler(h slog.Handler, perLevel map[slog.Level]rate.Limit) slog.Handler {
+	return newSampledHandler(h, perLevel, time.Now)
+}
+
+func newSampledHandler(h slog.Handler, perLevel map[slog.Level]rate.Limit, now func() time.Time) *sampledHandler {
+	s := &sampledState{
+		base:     h,
+		now:      now,
+		limiters: make(map[slog.Level]*rate.Limiter, len(perLevel)),
+		dropped:  make(map[slog.Level]*atomic.Int64, len(perLevel)),
+		// The first summary is written a second after creation.
+		lastSummary: now(),
+	}
+	for l, limit := range perLevel {
+		if limit < 0 || math.IsNaN(float64(limit)) {
+			panic("slogtext: negative or NaN sampling rate")
+		}
+		if limit == rate.Inf {
+			continue
+		}
+		burst := 1
+		if limit > math.MaxInt32 {
+			burst = math.MaxInt32
+		} else if limit > 1 {
+			burst = int(math.Ceil(float64(limit)))
+		}
+		s.limiters[l] = rate.NewLimiter(limit, burst)
+		s.dropped[l] = new(atomic.Int64)
+	}
+	return &sampledHandler{h: h, s: s}
+}
+
+// Enabled implements [slog.Handler.Enabled]. It reports false,
+// counting the record as dropped, if the rate limit for level
+// has been reached.
+func (h *sampledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if !h.h.Enabled(ctx, level) {
+		return false
+	}
+	lim := h.s.limiters[level]
+	if lim == nil || lim.TokensAt(h.s.now()) >= 1 {
+		return true
+	}
+	h.s.dropped[level].Add(1)
+	return false
+}
+
+// WithAttrs implements [slog.Handler.WithAttrs].
+func (h *sampledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampledHandler{h: h.h.WithAttrs(attrs), s: h.s}
+}
+
+// WithGroup implements [slog.Handler.WithGroup].
+func (h *sampledHandler) WithGroup(name string) slog.Handler {
+	return &sampledHandler{h: h.h.WithGroup(name), s: h.s}
+}
+
+// Handle implements [slog.Handler.Handle].
+func (h *sampledHandler) Handle(ctx context.Context, r slog.Record) error {
+	now := h.s.now()
+	if lim := h.s.limiters[r.Level]; lim != nil && !lim.AllowN(now, 1) {
+		h.s.dropped[r.Level].Add(1)
+		return nil
+	}
+	if err := h.s.summarize(ctx, now); err != nil {
+		return err
+	}
+	return h.h.Handle(ctx, r)
+}
+
+// summarize writes the summaries of the records dropped
+// since the last ones, if it is time to do so.
+func (s *sampledState) summarize(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSummary) < sampledSummaryInterval {
+		return nil
+	}
+	levels := make([]slog.Level, 0, len(s.dropped))
+	for l := range s.dropped {
+		levels = append(levels, l)
+	}
+	slices.Sort(levels)
+	for _, l := range levels {
+		n := s.dropped[l].Swap(0)
+		if n == 0 {
+			continue
+		}
+		s.lastSummary = now
+		r := slog.NewRecord(now, l, strconv.FormatInt(n, 10)+" records dropped", 0)
+		if err := s.base.Handle(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/time/rate"
+)
+
+func TestSampledHandler(t *testing.T) {
+	var buf bytes.Buffer
+	now := testTime
+	clock := func() time.Time { return now }
+	h := newSampledHandler(NewHandler(&buf), map[slog.Level]rate.Limit{
+		slog.LevelInfo: 2,
+	}, clock)
+	h1 := h.WithAttrs([]slog.Attr{slog.Int("a", 1)})
+	ctx := context.Background()
+	log := func(level slog.Level, msg string) {
+		if h1.Enabled(ctx, level) {
+			if err := h1.Handle(ctx, slog.NewRecord(now, level, msg, 0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	logAt := func(msg string) {
+		log(slog.LevelInfo, msg)
+		log(slog.LevelWarn, msg)
+	}
+	// The first two records are allowed; the rest are dropped.
+	for i := 0; i < 5; i++ {
+		logAt("first")
+	}
+	if got, want := h.s.dropped[slog.LevelInfo].Load(), int64(3); got != want {
+		t.Errorf("got %d dropped records, want %d", got, want)
+	}
+	// By direct calls to Handle as well as after Enabled.
+	if err := h.Handle(ctx, slog.NewRecord(now, slog.LevelInfo, "direct", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.s.dropped[slog.LevelInfo].Load(), int64(4); got != want {
+		t.Errorf("got %d dropped records, want %d", got, want)
+	}
+	// After a second there are two more tokens, and the next
+	// record is preceded by the summary.
+	now = now.Add(time.Second)
+	logAt("second")
+	logAt("second")
+	logAt("second")
+
+	want := `` +
+		"time=2000-01-02T03:04:05.000Z level=INFO msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=WARN msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=INFO msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=WARN msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=WARN msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=WARN msg=first a=1\n" +
+		"time=2000-01-02T03:04:05.000Z level=WARN msg=first a=1\n" +
+		"time=2000-01-02T03:04:06.000Z level=INFO msg=\"4 records dropped\"\n" +
+		"time=2000-01-02T03:04:06.000Z level=INFO msg=second a=1\n" +
+		"time=2000-01-02T03:04:06.000Z level=WARN msg=second a=1\n" +
+		"time=2000-01-02T03:04:06.000Z level=INFO msg=second a=1\n" +
+		"time=2000-01-02T03:04:06.000Z level=WARN msg=second a=1\n" +
+		"time=2000-01-02T03:04:06.000Z level=WARN msg=second a=1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// The third record at INFO was dropped, but the
+	// summary waits for a second after the last one.
+	buf.Reset()
+	now = now.Add(500 * time.Millisecond)
+	logAt("third")
+	if got, want := buf.String(), "time=2000-01-02T03:04:06.500Z level=INFO msg=third a=1\ntime=2000-01-02T03:04:06.500Z level=WARN msg=third a=1\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	now = now.Add(500 * time.Millisecond)
+	buf.Reset()
+	log(slog.LevelWarn, "fourth")
+	if got, want := buf.String(), "time=2000-01-02T03:04:07.000Z level=INFO msg=\"1 records dropped\"\ntime=2000-01-02T03:04:07.000Z level=WARN msg=fourth a=1\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSampledHandlerInf(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewSampledHandler(NewHandler(&buf), map[slog.Level]rate.Limit{
+		slog.LevelInfo: rate.Inf,
+	})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if !h.Enabled(ctx, slog.LevelInfo) {
+			t.Fatal("Enabled returned false")
+		}
+		if err := h.Handle(ctx, slog.NewRecord(testTime, slog.LevelInfo, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := strings.Repeat("time=2000-01-02T03:04:05.000Z level=INFO msg=m\n", 3)
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSampledHandlerBadLimit(t *testing.T) {
+	for _, limit := range []rate.Limit{-1, rate.Limit(math.Inf(-1)), rate.Limit(math.NaN())} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic for limit %v", limit)
+				}
+			}()
+			NewSampledHandler(NewHandler(io.Discard), map[slog.Level]rate.Limit{slog.LevelInfo: limit})
+		}()
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+// rewriteSchema rewrites the attributes in the buffer from start
+// onwards in the order given by the Schema option.
+func (s *handleState) rewriteSchema(start int) {
+	spans := s.fieldSpans(start)
+	used := make([]bool, len(spans))
+	region := newBuffer()
+	defer region.Free()
+	region.Write((*s.buf)[start:])
+	*s.buf = (*s.buf)[:start]
+	s.fields = s.fields[:0]
+	for _, key := range s.h.opts.Schema {
+		if len(*s.buf) > 0 {
+			s.appendFieldSep()
+		}
+		s.markField(key)
+		found := false
+		for i, sp := range spans {
+			if !used[i] && sp.key == key {
+				s.buf.Write((*region)[sp.start:sp.end])
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.appendKeyString(key)
+			s.appendKVSep()
+		}
+	}
+	if s.h.opts.SchemaExtras != SchemaExtrasAppend {
+		return
+	}
+	extras := spans[:0]
+	for i, sp := range spans {
+		if !used[i] {
+			extras = append(extras, sp)
+		}
+	}
+	if s.h.opts.SortKeys {
+		sortSpans(extras)
+	}
+	for _, sp := range extras {
+		if len(*s.buf) > 0 {
+			s.appendFieldSep()
+		}
+		s.markField(sp.key)
+		s.buf.Write((*region)[sp.start:sp.end])
+	}
+}
@@ -0,0 +1,65 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestSchema(t *testing.T) {
+	schema := []string{"user", "g.op", "status", "took"}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "drop extras",
+			opts: Options{Schema: schema},
+			want: `level=INFO msg=m user=bob g.op=get status= took=5`,
+		},
+		{
+			name: "append extras",
+			opts: Options{Schema: schema, SchemaExtras: SchemaExtrasAppend},
+			want: `level=INFO msg=m user=bob g.op=get status= took=5 z=1 extra="x y" user=alice`,
+		},
+		{
+			name: "sorted extras",
+			opts: Options{Schema: schema, SchemaExtras: SchemaExtrasAppend, SortKeys: true},
+			want: `level=INFO msg=m user=bob g.op=get status= took=5 extra="x y" user=alice z=1`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, test.opts)
+			h = h.WithAttrs([]slog.Attr{slog.Int("took", 5), slog.Int("z", 1)})
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(
+				slog.String("extra", "x y"),
+				slog.String("user", "bob"),
+				slog.Group("g", slog.String("op", "get")),
+				slog.String("user", "alice"),
+			)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSchemaNoAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{Schema: []string{"a", "b c"}})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `level=INFO msg=m a= "b c"=`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
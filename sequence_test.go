@@ -0,0 +1,139 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestSequence(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{Sequence: true})
+	h2 := h.WithAttrs([]slog.Attr{slog.Int("a", 1)}).WithGroup("g")
+	ctx := context.Background()
+	for i, h := range []slog.Handler{h, h2, h, h2} {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("i", i))
+		if err := h.Handle(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `level=INFO msg=m seq=1 i=0
+level=INFO msg=m seq=2 a=1 g.i=1
+level=INFO msg=m seq=3 i=2
+level=INFO msg=m seq=4 a=1 g.i=3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// A handler with a different writer has its own sequence.
+	buf.Reset()
+	if err := h.WithWriter(&buf).Handle(ctx, slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m seq=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSequenceReplaceAttr(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == SequenceKey && len(groups) == 0 {
+					return slog.Uint64("n", a.Value.Uint64()*10)
+				}
+				return a
+			},
+		},
+		Sequence: true,
+	})
+	for i := 0; i < 2; i++ {
+		if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := buf.String(), "level=INFO msg=m n=10\nlevel=INFO msg=m n=20\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSequenceConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{Sequence: true})
+	const (
+		goroutines = 10
+		records    = 100
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		hh := h.WithAttrs([]slog.Attr{slog.Int("g", i)})
+		go func() {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				hh.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+			}
+		}()
+	}
+	wg.Wait()
+	re := regexp.MustCompile(`^level=INFO msg=m seq=(\d+) g=(\d+)$`)
+	seen := make(map[uint64]bool)
+	last := make(map[string]uint64)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, line := range lines {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("unexpected line %q", line)
+		}
+		n, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[n] {
+			t.Errorf("duplicate sequence number %d", n)
+		}
+		seen[n] = true
+		// Records from each goroutine are numbered in order.
+		if n <= last[m[2]] {
+			t.Errorf("goroutine %s: sequence number %d follows %d", m[2], n, last[m[2]])
+		}
+		last[m[2]] = n
+	}
+	for n := uint64(1); n <= goroutines*records; n++ {
+		if !seen[n] {
+			t.Errorf("missing sequence number %d", n)
+		}
+	}
+}
+
+func TestSequenceExplode(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{Sequence: true, ExplodeKey: "ids"})
+	ctx := context.Background()
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Any("ids", []int{1, 2, 3}))
+	if err := h.Handle(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Handle(ctx, slog.NewRecord(time.Time{}, slog.LevelInfo, "n", 0)); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m seq=1 id=1
+level=INFO msg=m seq=2 id=2
+level=INFO msg=m seq=3 id=3
+level=INFO msg=n seq=4
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slog"
+)
+
+// appendJSONObject appends attrs to buf as a JSON object,
+// as for [Options.JSONSidecar]. Values that cannot be formatted
+// are written as errors preceded by errPrefix.
+func appendJSONObject(buf *buffer, attrs []slog.Attr, errPrefix string) {
+	buf.WriteByte('{')
+	for i, a := range attrs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		appendJSONString(buf, a.Key)
+		buf.WriteByte(':')
+		appendJSONValue(buf, a.Value, errPrefix)
+	}
+	buf.WriteByte('}')
+}
+
+func appendJSONValue(buf *buffer, v slog.Value, errPrefix string) {
+	switch v.Kind() {
+	case slog.KindString:
+		appendJSONString(buf, v.String())
+	case slog.KindInt64:
+		*buf = strconv.AppendInt(*buf, v.Int64(), 10)
+	case slog.KindUint64:
+		*buf = strconv.AppendUint(*buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		if f := v.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no representation of these.
+			appendJSONString(buf, strconv.FormatFloat(f, 'g', -1, 64))
+		} else {
+			*buf = strconv.AppendFloat(*buf, f, 'g', -1, 64)
+		}
+	case slog.KindBool:
+		*buf = strconv.AppendBool(*buf, v.Bool())
+	case slog.KindDuration:
+		*buf = strconv.AppendInt(*buf, int64(v.Duration()), 10)
+	case slog.KindTime:
+		buf.WriteByte('"')
+		writeTimeRFC3339Millis(buf, v.Time())
+		buf.WriteByte('"')
+	case slog.KindGroup:
+		appendJSONObject(buf, v.Group(), errPrefix)
+	default:
+		x := v.Any()
+		if err, ok := x.(error); ok {
+			appendJSONString(buf, err.Error())
+			return
+		}
+		e := newJSONEncoder()
+		defer e.free()
+		data, err := e.appendMarshal(x, *buf)
+		if err != nil {
+			appendJSONString(buf, errPrefix+err.Error())
+			return
+		}
+		if string(data[len(*buf):]) == "<nil>" {
+			// appendMarshal spells null as in the text.
+			data = append(data[:len(*buf)], "null"...)
+		}
+		*buf = data
+	}
+}
+
+// appendJSONString appends s to buf as a JSON string.
+func appendJSONString(buf *buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			switch {
+			case safeSet[b]:
+				buf.WriteByte(b)
+			case b == '"' || b == '\\':
+				buf.WriteByte('\\')
+				buf.WriteByte(b)
+			case b == '\n':
+				buf.WriteString(`\n`)
+			case b == '\r':
+				buf.WriteString(`\r`)
+			case b == '\t':
+				buf.WriteString(`\t`)
+			default:
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[b>>4])
+				buf.WriteByte(hex[b&0xf])
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteString(`�`)
+		} else {
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.WriteByte('"')
+}
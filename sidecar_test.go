@@ -0,0 +1,92 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestJSONSidecar(t *testing.T) {
+	var buf bytes.Buffer
+	w := &countingWriter{w: &buf}
+	var h slog.Handler = NewHandlerWithOptions(w, Options{JSONSidecar: true})
+	h = h.WithAttrs([]slog.Attr{slog.String("a", "x y")}).WithGroup("g")
+	r := slog.NewRecord(testTime, slog.LevelWarn, "hello\tthere", 0)
+	r.AddAttrs(
+		slog.Int("n", 1),
+		slog.Duration("d", time.Second),
+		slog.Float64("nan", math.NaN()),
+		slog.Any("err", errors.New("bad")),
+		slog.Any("nil", nil),
+		slog.Any("m", map[string]int{"k": 2}),
+		slog.Group("s", slog.Bool("b", true), slog.String("q", `"<é>"`)),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if w.n != 1 {
+		t.Errorf("got %d writes, want 1", w.n)
+	}
+	want := `time=2000-01-02T03:04:05.000Z level=WARN msg="hello\tthere" a="x y" g.n=1 g.d=1s g.nan="NaN" g.err=bad g.nil=<nil> g.m={"k":2} g.s.b=true g.s.q="\"<é>\""` + "\n" +
+		`{"time":"2000-01-02T03:04:05.000Z","level":"WARN","msg":"hello\tthere","a":"x y","g":{"n":1,"d":1000000000,"nan":"NaN","err":"bad","nil":null,"m":{"k":2},"s":{"b":true,"q":"\"<é>\""}}}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	lines := bytes.Split(buf.Bytes(), []byte("\n"))
+	var m map[string]any
+	if err := json.Unmarshal(lines[1], &m); err != nil {
+		t.Fatalf("invalid JSON sidecar: %v", err)
+	}
+}
+
+func TestJSONSidecarLineTerminator(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{JSONSidecar: true, LineTerminator: "\r\n"})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\r\n"+`{"level":"INFO","msg":"m"}`+"\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for JSONSidecar with NoNewline")
+		}
+	}()
+	NewHandlerWithOptions(&buf, Options{JSONSidecar: true, NoNewline: true})
+}
+
+func TestJSONSidecarMonotonic(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{JSONSidecar: true, AddMonotonic: true, Sequence: true})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	mono := regexp.MustCompile(` mono=(\d+)`).FindStringSubmatch(lines[0])
+	if mono == nil {
+		t.Fatalf("no monotonic reading in %q", lines[0])
+	}
+	var m struct {
+		Seq  uint64 `json:"seq"`
+		Mono int64  `json:"mono"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil {
+		t.Fatalf("invalid JSON sidecar: %v", err)
+	}
+	if got, want := strconv.FormatInt(m.Mono, 10), mono[1]; got != want {
+		t.Errorf("JSON has mono %s, text has %s", got, want)
+	}
+	if m.Seq != 1 || !strings.Contains(lines[0], " seq=1 ") {
+		t.Errorf("sequence numbers differ: %q", buf.String())
+	}
+}
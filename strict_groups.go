@@ -0,0 +1,62 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// BadGroupKey is the key used by the handler for the diagnostic
+// written when [Options.StrictGroups] is set and
+// [Handler.WithGroup] is called with an invalid group name.
+const BadGroupKey = "!BADGROUP"
+
+// badGroup records a group name rejected by StrictGroups.
+// It is shared by all handlers derived from the one that
+// opened the group, so the diagnostic is written only once.
+type badGroup struct {
+	name     string
+	reason   string
+	reported atomic.Bool
+}
+
+// checkGroupName returns a non-nil badGroup if name is not
+// a valid group name for h when StrictGroups is set.
+func (h *Handler) checkGroupName(name string) *badGroup {
+	if !h.opts.StrictGroups {
+		return nil
+	}
+	sep := h.opts.GroupSeparator
+	if sep == "" {
+		sep = keyComponentSep
+	}
+	var reason string
+	switch {
+	case strings.Contains(name, sep):
+		reason = fmt.Sprintf("contains group separator %q", sep)
+	case needsQuoting(name):
+		reason = "needs quoting"
+	default:
+		return nil
+	}
+	return &badGroup{
+		name:   name,
+		reason: reason,
+	}
+}
+
+// appendBadGroups writes a diagnostic for each invalid group
+// that has not yet been reported.
+func (s *handleState) appendBadGroups() {
+	for _, g := range s.h.badGroups {
+		if !g.reported.CompareAndSwap(false, true) {
+			continue
+		}
+		s.appendKey(BadGroupKey)
+		s.appendString(fmt.Sprintf("%sgroup name %q %s", s.errorPrefix(), g.name, g.reason))
+	}
+}
@@ -0,0 +1,88 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestStrictGroups(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  Options
+		group string
+		want  []string
+	}{{
+		name:  "separator",
+		opts:  Options{StrictGroups: true},
+		group: "a.b",
+		want: []string{
+			`level=INFO msg=m !BADGROUP="!ERROR:group name \"a.b\" contains group separator \".\"" a.b.x=1`,
+			`level=INFO msg=m a.b.x=1`,
+		},
+	}, {
+		name:  "custom-separator",
+		opts:  Options{StrictGroups: true, GroupSeparator: "/"},
+		group: "a/b",
+		want: []string{
+			`level=INFO msg=m !BADGROUP="!ERROR:group name \"a/b\" contains group separator \"/\"" a/b/x=1`,
+			`level=INFO msg=m a/b/x=1`,
+		},
+	}, {
+		name:  "custom-separator-dot",
+		opts:  Options{StrictGroups: true, GroupSeparator: "/"},
+		group: "a.b",
+		want: []string{
+			`level=INFO msg=m a.b/x=1`,
+			`level=INFO msg=m a.b/x=1`,
+		},
+	}, {
+		name:  "needs-quoting",
+		opts:  Options{StrictGroups: true},
+		group: "a b",
+		want: []string{
+			`level=INFO msg=m !BADGROUP="!ERROR:group name \"a b\" needs quoting" "a b.x"=1`,
+			`level=INFO msg=m "a b.x"=1`,
+		},
+	}, {
+		name:  "valid",
+		opts:  Options{StrictGroups: true},
+		group: "ab",
+		want: []string{
+			`level=INFO msg=m ab.x=1`,
+			`level=INFO msg=m ab.x=1`,
+		},
+	}, {
+		name:  "not-strict",
+		group: "a.b",
+		want: []string{
+			`level=INFO msg=m a.b.x=1`,
+			`level=INFO msg=m a.b.x=1`,
+		},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts).WithGroup(test.group)
+			for i := 0; i < 2; i++ {
+				// Alternate between the handler and one derived
+				// from it to check that the diagnostic is shared.
+				h := h
+				if i == 1 {
+					h = h.WithAttrs(nil)
+				}
+				buf.Reset()
+				r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+				r.AddAttrs(slog.Int("x", 1))
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := buf.String(), test.want[i]+"\n"; got != want {
+					t.Errorf("record %d: got %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// recordStats counts the records handled by a Handler
+// and all the handlers derived from it.
+type recordStats struct {
+	start time.Time
+	// counts holds the number of records at levels
+	// DEBUG, INFO, WARN and ERROR, where each count includes
+	// the levels above it up to the next.
+	counts [4]atomic.Int64
+	closed atomic.Bool
+}
+
+var statsLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func newRecordStats() *recordStats {
+	return &recordStats{start: time.Now()}
+}
+
+func (st *recordStats) add(l slog.Level) {
+	i := 0
+	switch {
+	case l >= slog.LevelError:
+		i = 3
+	case l >= slog.LevelWarn:
+		i = 2
+	case l >= slog.LevelInfo:
+		i = 1
+	}
+	st.counts[i].Add(1)
+}
+
+// appendSummary appends a summary comment line to buf,
+// without the line prefix or terminator.
+func (st *recordStats) appendSummary(buf *buffer) {
+	var total int64
+	var counts [len(statsLevelNames)]int64
+	for i := range counts {
+		counts[i] = st.counts[i].Load()
+		total += counts[i]
+	}
+	buf.WriteString("# summary records=")
+	*buf = strconv.AppendInt(*buf, total, 10)
+	for i, name := range statsLevelNames {
+		buf.WriteByte(' ')
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		*buf = strconv.AppendInt(*buf, counts[i], 10)
+	}
+	buf.WriteString(" duration=")
+	buf.WriteString(time.Since(st.start).String())
+}
+
+// Close writes a summary line if the EmitSummaryOnClose option is set.
+// Only the first call to Close on h or any handler derived from it
+// writes the summary; later calls do nothing. Close does
+// not close the underlying writer.
+func (h *Handler) Close() error {
+	if h.stats == nil || !h.stats.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	buf := newBuffer()
+	defer buf.Free()
+	buf.WriteString(h.opts.LinePrefix)
+	h.stats.appendSummary(buf)
+	h.appendLineTerminator(buf)
+	_, err := h.write(slog.LevelInfo, *buf)
+	return err
+}
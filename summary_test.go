@@ -0,0 +1,74 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestEmitSummaryOnClose(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions:     slog.HandlerOptions{Level: slog.LevelDebug},
+		EmitSummaryOnClose: true,
+	})
+	h2 := h.WithGroup("g")
+	for i, l := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelInfo, slog.LevelWarn + 1, slog.LevelError} {
+		hh := slog.Handler(h)
+		if i%2 == 1 {
+			hh = h2
+		}
+		if err := hh.Handle(context.Background(), slog.NewRecord(time.Time{}, l, "m", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 6:\n%s", len(lines), buf.String())
+	}
+	re := regexp.MustCompile(`^# summary records=5 debug=1 info=2 warn=1 error=1 duration=\S+$`)
+	if !re.MatchString(lines[5]) {
+		t.Errorf("summary %q does not match %s", lines[5], re)
+	}
+}
+
+func TestCloseWithoutSummary(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestEmitSummaryOnCloseLineTerminator(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		EmitSummaryOnClose: true,
+		LinePrefix:         "> ",
+		LineTerminator:     "\x00",
+	})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^> level=INFO msg=m\x00> # summary records=1 debug=0 info=1 warn=0 error=0 duration=\S+\x00$`)
+	if got := buf.String(); !re.MatchString(got) {
+		t.Errorf("got %q, want match for %s", got, re)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "golang.org/x/exp/slog"
+
+// TagKey is the key used by the handler for the tags
+// added by [Options.TagRules].
+const TagKey = "tag"
+
+// TagRule classifies records: a record for which Match
+// returns true is tagged with Tag.
+type TagRule struct {
+	Match func(slog.Record) bool
+	Tag   string
+}
@@ -0,0 +1,68 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestTagRules(t *testing.T) {
+	rules := []TagRule{{
+		Match: func(r slog.Record) bool {
+			return strings.Contains(r.Message, "timeout")
+		},
+		Tag: "network",
+	}, {
+		Match: func(r slog.Record) bool {
+			return r.Level >= slog.LevelError
+		},
+		Tag: "alert",
+	}}
+	for _, test := range []struct {
+		name  string
+		level slog.Level
+		msg   string
+		opts  Options
+		want  string
+	}{{
+		name:  "both",
+		level: slog.LevelError,
+		msg:   "read timeout",
+		want:  `level=ERROR msg="read timeout" tag=network tag=alert g.a=1`,
+	}, {
+		name:  "one",
+		level: slog.LevelInfo,
+		msg:   "timeout",
+		want:  `level=INFO msg=timeout tag=network g.a=1`,
+	}, {
+		name:  "none",
+		level: slog.LevelInfo,
+		msg:   "ok",
+		want:  `level=INFO msg=ok g.a=1`,
+	}, {
+		name:  "xml",
+		level: slog.LevelError,
+		msg:   "timeout",
+		opts:  Options{Layout: LayoutXML},
+		want:  `<record level="ERROR"><msg>timeout</msg><tag>network</tag><tag>alert</tag><g><a>1</a></g></record>`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := test.opts
+			opts.TagRules = rules
+			h := NewHandlerWithOptions(&buf, opts).WithGroup("g")
+			r := slog.NewRecord(time.Time{}, test.level, test.msg, 0)
+			r.AddAttrs(slog.Int("a", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
@@ -10,22 +10,1024 @@
 package slogtext
 
 import (
+	"bytes"
 	"context"
 	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"golang.org/x/exp/slog"
+	"math"
+	"os"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
 )
 
-func NewHandlerWithOpts(w io.Writer, opts slog.HandlerOptions) *Handler {
-	return &Handler{
-		w:    w,
-		opts: opts,
+// Keys of built-in attributes added by some of the options.
+const (
+	// SequenceKey is the key used by the handler for the record's
+	// sequence number when [Options.Sequence] is set.
+	SequenceKey = "seq"
+
+	// HostKey is the key used by the handler for the host name
+	// when [Options.IncludeHostname] is set.
+	HostKey = "host"
+
+	// PIDKey is the key used by the handler for the process ID
+	// when [Options.IncludePID] is set.
+	PIDKey = "pid"
+)
+
+// Options holds the options for a Handler. It embeds
+// [slog.HandlerOptions] so that all the standard options are available,
+// and adds further options specific to this package.
+type Options struct {
+	slog.HandlerOptions
+
+	// DistinguishEmptyContainers causes nil values of kind KindAny,
+	// including typed nils such as a nil slice or map, to be
+	// rendered as null rather than <nil>. Empty non-nil slices
+	// and maps are always rendered as [] and {} respectively.
+	DistinguishEmptyContainers bool
+
+	// GraphiteMode causes records that carry both a string
+	// GraphiteMetricKey attribute and a numeric GraphiteValueKey
+	// attribute to be written as a Graphite plaintext line
+	// ("NAME VALUE TIMESTAMP") instead of the usual key=value
+	// format. Characters in the metric name that Graphite does not
+	// allow are replaced with underscores. Other records are
+	// formatted normally.
+	GraphiteMode bool
+
+	// Sample, if non-nil, is consulted by Enabled for records
+	// that pass the minimum level check. If it returns false, the record
+	// is discarded before it is constructed, so no attributes
+	// are computed for it. Because only the level is known at that
+	// point, sampling decisions can be based on the level alone.
+	//
+	// Each call to Enabled consumes one sampling decision, so
+	// Enabled should be called once per record, as [slog.Logger]
+	// does; guarding a log call with an explicit Logger.Enabled
+	// check consumes a second one. Handle does not consult Sample.
+	// See [SampleEvery].
+	Sample func(level slog.Level) bool
+
+	// KeyAliases maps attribute keys to alternative keys.
+	// An attribute whose key (not including any group prefix)
+	// is in the map is emitted twice: once under its original key
+	// and then again under the alias, within the same group.
+	// This can ease migration from one key name to another.
+	// Aliases apply to the built-in attributes, such as
+	// slog.MessageKey, whether or not ReplaceAttr is set, and
+	// KeyPattern is applied to an alias just as it is to the
+	// original key.
+	KeyAliases map[string]string
+
+	// AddRequestID causes the request ID stored in the context
+	// by [ContextWithRequestID], if any, to be emitted after
+	// the message with the key [RequestIDKey].
+	AddRequestID bool
+
+	// GoSyntaxValues causes values of kind KindAny to be formatted
+	// with the %#v verb of the fmt package instead of as JSON,
+	// quoted if necessary. This shows the Go type and unexported
+	// fields, which can help when debugging, but the output is
+	// neither stable nor machine-readable, so it should not be used
+	// in production.
+	GoSyntaxValues bool
+
+	// TimeLayout, if non-empty, holds a layout as accepted by
+	// [time.Time.Format] that is used to format the built-in time
+	// and all time values. The result is quoted if necessary.
+	TimeLayout string
+
+	// AppendTime, if non-nil, is used to format the built-in time
+	// and all time values, appending the formatted time to dst and
+	// returning the result, which is quoted if necessary.
+	// It takes precedence over TimeLayout.
+	//
+	// If neither AppendTime nor TimeLayout are set, times
+	// are formatted as determined by TimeFormat, by default in
+	// RFC3339 format with millisecond precision.
+	AppendTime func(dst []byte, t time.Time) []byte
+
+	// Color determines whether the level value is colorized with
+	// ANSI escape sequences. Colors are added after any ReplaceAttr
+	// function has been called and after quoting.
+	Color ColorMode
+
+	// ColorLine causes the whole line, rather than just
+	// the level value, to be colorized when color is enabled.
+	ColorLine bool
+
+	// LevelColors maps levels to the parameters of an ANSI SGR
+	// sequence, such as "31" for red or "1;33" for bold yellow.
+	// A level without an entry uses the color of the nearest
+	// lower level that has one. If nil, [DefaultLevelColors]
+	// is used.
+	LevelColors map[slog.Level]string
+
+	// DurationCompact causes trailing zero components to be
+	// omitted from durations, so that, for example, one hour is
+	// written as 1h rather than 1h0m0s.
+	DurationCompact bool
+
+	// DurationFormat determines how durations are formatted.
+	// DurationCompact applies only to DurationString, the default.
+	DurationFormat DurationFormat
+
+	// ExplodeKey, if non-empty, names a record attribute whose
+	// value, if it is a non-empty slice or array, causes the record
+	// to be written as one line for each element. Each line holds
+	// the same built-in and other attributes, with the slice attribute
+	// replaced by a single element under the singular form of the
+	// key (the key with any trailing "s" removed). All the lines
+	// are written with a single call to Write.
+	//
+	// Records without the attribute, or where its value is not
+	// a slice, are written as usual.
+	ExplodeKey string
+
+	// KVSeparator holds the separator written between each key and
+	// its value. If empty, "=" is used. It must not contain
+	// spaces, quotes, control characters or backslashes.
+	// Keys and values containing the separator are quoted;
+	// when it is not "=", keys and values containing "="
+	// are not quoted on that account.
+	KVSeparator string
+
+	// DittoRepeatedAttrs holds keys (not including any group
+	// prefix) of record attributes whose value is replaced by
+	// [DittoMarker] when it is the same as the value of the same
+	// attribute in the previous record written by the handler or
+	// any handler derived from it.
+	//
+	// This is intended to reduce noise for human readers only:
+	// the output cannot be interpreted without the preceding
+	// records, and when records are logged concurrently, the
+	// "previous" record is the one most recently formatted,
+	// which might not be the one most recently written.
+	DittoRepeatedAttrs []string
+
+	// FieldSeparator holds the separator written between
+	// attributes. If empty, a single space is used. A tab
+	// can make the output easier to process with column-based
+	// tools. Keys and values containing a non-whitespace
+	// separator are quoted.
+	FieldSeparator string
+
+	// EmitSummaryOnClose causes the handler to count the records
+	// it handles and to write a summary line when [Handler.Close]
+	// is called. The summary is a comment line starting with "#"
+	// holding the total number of records, the number at each
+	// of the standard levels (each including the custom levels
+	// above it), and the time since the handler was created,
+	// for example:
+	//
+	//	# summary records=3 debug=0 info=2 warn=0 error=1 duration=1.5s
+	EmitSummaryOnClose bool
+
+	// LineTerminator holds the bytes written at the end of each
+	// record, such as "\r\n" or "\x00". If empty, a newline
+	// is used, unless NoNewline is set, in which case
+	// nothing is written after the record.
+	LineTerminator string
+	NoNewline      bool
+
+	// KeyPattern, if non-nil, is matched against the key of each
+	// attribute (not including any group prefix) after any
+	// ReplaceAttr function has been called. KeyMismatch
+	// determines what happens to attributes whose key does not
+	// match.
+	KeyPattern  *regexp.Regexp
+	KeyMismatch KeyMismatchAction
+
+	// KeyUnits maps attribute keys (not including any group
+	// prefix) to units that are appended to numeric values with
+	// those keys, so that, for example, a latency attribute
+	// can be written as latency=12ms. The result is quoted
+	// if necessary. Non-numeric values are left alone.
+	KeyUnits map[string]string
+
+	// SortKeys causes the attributes other than the built-in ones
+	// to be sorted by their fully qualified keys, including
+	// any group prefix. Attributes with the same key keep their
+	// original order, and ReplaceAttr is still called on
+	// attributes in their original order.
+	SortKeys bool
+
+	// AbbreviateGroupPrefixes causes group prefixes that are used by
+	// more than one attribute in a record to be replaced by short
+	// codes of the form @N, numbered from 1 in order of first use.
+	// A legend mapping each code to its prefix is written before
+	// the attributes other than the built-in ones. For example:
+	//
+	//	msg=m @1=service.db.conn @1.open=true @1.idle=3 other.x=1
+	//
+	// Use [ExpandGroupPrefixes] to expand a line to its
+	// unabbreviated form.
+	AbbreviateGroupPrefixes bool
+
+	// QuoteFunc, if non-nil, is called to decide whether a
+	// string should be quoted, in place of the default rules
+	// described in [Handler.Handle]. It is called for both keys,
+	// including fully qualified keys, and values that are strings,
+	// times or the result of MarshalText. Numbers, booleans
+	// and JSON values are not passed to it. Quoting a string
+	// that needs quoting by the default rules is always safe;
+	// not quoting one may make the output ambiguous.
+	QuoteFunc func(s string) bool
+
+	// KeyQuoteFunc and ValueQuoteFunc, if non-nil, are used
+	// instead of QuoteFunc for keys and values respectively.
+	// KeyQuoteFunc is called with the fully qualified key,
+	// including any group prefix.
+	KeyQuoteFunc   func(s string) bool
+	ValueQuoteFunc func(s string) bool
+
+	// MaxKeyBytes, if positive, limits the length of fully
+	// qualified keys. A longer key is truncated to at most
+	// MaxKeyBytes bytes, without splitting a UTF-8 sequence,
+	// and followed by "…".
+	MaxKeyBytes int
+
+	// Layout determines the overall format of each record.
+	// Most of the other formatting options apply
+	// only to LayoutText, the default.
+	Layout Layout
+
+	// BytesEncoding determines how values whose underlying
+	// type is []byte are formatted.
+	BytesEncoding BytesEncoding
+
+	// AddDedupHash adds an attribute with key DedupKey to the end
+	// of each record, holding a hash of the record's content
+	// so that a collector can discard duplicate deliveries
+	// of the same record. The hash covers the level, message and
+	// the keys and values of the other attributes, in key order,
+	// but not the time or the other built-in attributes, such as
+	// the source, sequence number, request ID, monotonic clock
+	// reading, tags, host name and process ID, which can differ
+	// between duplicates. It does not depend on how the values are
+	// formatted, so options such as DittoRepeatedAttrs, Color and
+	// QuoteChar do not affect it. It is stable across runs.
+	AddDedupHash bool
+
+	// Locale, if set, causes integer and floating-point values
+	// to be formatted with the grouping and decimal separators
+	// of the given locale, for human readers. For example,
+	// 1234.56 is written as "1.234,56" for German. Such numbers
+	// are always quoted. The default is the machine-readable
+	// format of the strconv package.
+	Locale language.Tag
+
+	// NonFiniteFloat determines how NaN and infinite
+	// floating-point values are formatted.
+	NonFiniteFloat NonFiniteFloat
+
+	// AddMonotonic causes the handler to add an attribute with key
+	// MonotonicKey after the message, holding the number of
+	// nanoseconds since the package was initialized according to the
+	// monotonic clock. This orders records from the same process
+	// even when their times are equal or the wall clock is changed.
+	AddMonotonic bool
+
+	// SourceLevel, if non-nil, causes the source location to be
+	// added to records at or above its level even when
+	// AddSource is false, so that, for example,
+	// only errors carry their source.
+	SourceLevel slog.Leveler
+
+	// RecoverFromPanics causes Handle to recover from a panic while
+	// formatting a record, such as one in a ReplaceAttr function or
+	// a LogValue or MarshalText method. Instead of the record, the
+	// handler writes a line with its level and message and an
+	// "error" attribute holding "!ERROR:panic:" followed by the
+	// panic value.
+	RecoverFromPanics bool
+
+	// LevelLabels maps levels to the text used for them in the
+	// built-in level attribute, overriding [slog.Level.String].
+	// Levels not in the map use their usual text. It is not
+	// consulted if ReplaceAttr is set.
+	LevelLabels map[slog.Level]string
+
+	// LowercaseLevels causes the four standard levels to be written
+	// as debug, info, warn and error, when they are not
+	// in LevelLabels. Other levels, such as INFO+2,
+	// are unaffected.
+	LowercaseLevels bool
+
+	// QuoteChar, if non-zero, is used instead of a double quote
+	// to quote keys and values. Within quoted strings it is escaped
+	// with a backslash and double quotes are not escaped; the escapes
+	// are otherwise as for [strconv.Quote]. Keys and values containing
+	// QuoteChar are always quoted. It must be an ASCII punctuation
+	// or symbol character other than a backslash and '=' and must not appear
+	// in KVSeparator or FieldSeparator.
+	QuoteChar byte
+
+	// SourceMode determines how much of the source file's
+	// path is written when the source location is added.
+	SourceMode SourceMode
+
+	// SourceWithFunction causes the name of the calling function,
+	// qualified by its package name, to be written before the source
+	// file and line, separated by "@", as in
+	// slogtext.TestHandler@handler_test.go:42.
+	SourceWithFunction bool
+
+	// BareBoolFlags causes boolean attributes to be written as flags:
+	// a true attribute is written as its key alone, with no
+	// separator or value, and a false one is omitted.
+	BareBoolFlags bool
+
+	// NegateFalseFlags causes false boolean attributes to be written
+	// as their key preceded by "!" when BareBoolFlags is set,
+	// instead of being omitted.
+	NegateFalseFlags bool
+
+	// SourceAsGroup causes the source location to be written as
+	// a group with key "source" holding the attributes "file", "line"
+	// and "function", rather than as a single FILE:LINE value.
+	// ReplaceAttr is called for each of them with the group "source".
+	SourceAsGroup bool
+
+	// JSONIndent, if non-empty, causes values formatted as JSON to be
+	// indented, using JSONIndent for each level of indentation as in
+	// [json.MarshalIndent]. The result is quoted, so the lines
+	// appear as \n escapes, unless MultilineJSON is set.
+	JSONIndent string
+
+	// MultilineJSON causes indented JSON values to be written
+	// unquoted across several physical lines, with each line after
+	// the first starting with JSONContinuation. The whole record
+	// is still written with a single call to Write.
+	MultilineJSON bool
+
+	// WriteTimeout, if positive, limits the time spent writing each
+	// record. If the writer has a SetWriteDeadline method, as
+	// net.Conn does, the deadline is set for each write. Otherwise
+	// the write is made in a separate goroutine and is abandoned
+	// if it takes longer than WriteTimeout. In either case Handle
+	// returns ErrWriteTimeout. Until an abandoned write returns,
+	// records are dropped and Handle returns ErrWriteTimeout
+	// immediately.
+	WriteTimeout time.Duration
+
+	// GroupSeparator, if non-empty, is used instead of "." to
+	// separate group names from each other and from keys.
+	GroupSeparator string
+
+	// Schema, if non-empty, holds the fully qualified keys of the
+	// attributes, other than the built-in ones, that every record
+	// should have, in order. Each record has exactly those attributes
+	// in that order, with an empty value for any that are missing,
+	// followed by any others as determined by SchemaExtras. If a key
+	// occurs more than once, the first occurrence is used and the others
+	// are treated as extras. When Schema is set, AbbreviateGroupPrefixes
+	// is ignored and SortKeys applies only to the extras.
+	Schema []string
+
+	// SchemaExtras determines what happens to attributes
+	// whose keys are not in the Schema.
+	SchemaExtras SchemaExtras
+
+	// ContextAttrs holds functions that extract attributes from the
+	// context passed to Handle, such as a trace ID stored there by
+	// middleware. Their attributes are written after those from
+	// WithAttrs and before the record's own, in any groups
+	// started with WithGroup, and are passed to ReplaceAttr.
+	// The functions are not called if the context is nil.
+	ContextAttrs []func(context.Context) []slog.Attr
+
+	// SourcePrivacy causes the source location to be written as the
+	// calling function, qualified by its package name, and the line,
+	// with no file path, as in slogtext.TestHandler:42, so that build
+	// paths are not revealed. It takes precedence over SourceMode and
+	// SourceWithFunction, and when SourceAsGroup is set
+	// it omits the "file" attribute.
+	SourcePrivacy bool
+
+	// LevelFromContext, if non-nil, is called with the context passed
+	// to Enabled and Handle. If it returns true, its level is used as
+	// the minimum level instead of Level, so that, for example, debug
+	// logging can be enabled for a single request. Handle discards
+	// records below the minimum level for its context.
+	LevelFromContext func(context.Context) (slog.Level, bool)
+
+	// JSONSidecar causes each record to be followed by a second
+	// line holding the same record as a JSON object, so that people
+	// can read the first line and programs the second. Both lines are
+	// written with a single call to Write. In the JSON, groups are
+	// nested objects, times are RFC 3339 strings with millisecond
+	// precision, durations are numbers of nanoseconds, errors are
+	// their messages and other values of kind KindAny use their JSON
+	// encoding. Most other formatting options affect only the text
+	// line. Each line ends with LineTerminator, so JSONSidecar
+	// cannot be used with NoNewline.
+	JSONSidecar bool
+
+	// TagRules classifies records for routing or alerting.
+	// For each rule whose Match function returns true for a record,
+	// in order, the handler adds an attribute with key TagKey and
+	// the rule's tag as its value, after the message and the other
+	// built-in attributes, so a record can have several tags.
+	TagRules []TagRule
+
+	// MergeAdjacentGroups causes WithGroup to ignore a group with
+	// the same name as the innermost group already started, so that
+	// WithGroup("g").WithGroup("g") gives keys such as g.key rather
+	// than g.g.key. Groups in the attributes themselves are unaffected.
+	MergeAdjacentGroups bool
+
+	// MaxJSONDepth, if positive, limits the nesting of the objects
+	// and arrays in values formatted as JSON. Before formatting such
+	// a value, the handler checks it, and formats an error instead if
+	// it is nested more deeply or refers to itself, in which case the
+	// error is "json cycle detected". The check does not look inside
+	// values that implement json.Marshaler or encoding.TextMarshaler.
+	// Without it, a value that refers to itself is still
+	// reported as a cycle, but only after the JSON encoder has
+	// followed the cycle many times.
+	MaxJSONDepth int
+
+	// SelfDescribing causes the handler to write a format descriptor
+	// line before the first record written to its writer, when any of
+	// the options that affect how the output is parsed differ from
+	// their defaults, so that a generic reader can configure itself
+	// without being told the options. See [ParseDescriptor].
+	//
+	// The descriptor line has the form
+	//
+	//	# slogtext v=1 NAME=VALUE...
+	//
+	// where each VALUE is either a bare word or a Go quoted
+	// string, and each NAME is one of the following, appearing
+	// only if the option differs from its default:
+	//
+	//	layout    text, prototext or xml (Layout)
+	//	kvsep     quoted KVSeparator
+	//	fieldsep  quoted FieldSeparator
+	//	groupsep  quoted GroupSeparator
+	//	quote     quoted QuoteChar
+	//	eol       quoted LineTerminator, or "" with NoNewline
+	//	time      quoted TimeLayout, custom with AppendTime,
+	//	          or unixseconds, unixmillis or unixnanos (TimeFormat)
+	//	duration  string, seconds, millis, nanos or floatmillis (DurationFormat)
+	//	bytes     quoted, base64 or hex (BytesEncoding)
+	//
+	// The descriptor line always ends with a newline.
+	SelfDescribing bool
+
+	// MaxResolveDepth, if positive, limits the number of times the
+	// handler calls LogValue to resolve the value of an attribute
+	// passed to WithAttrs or returned by ReplaceAttr, which slog has
+	// not already resolved. If the value is still a LogValuer after that many
+	// calls, the handler writes "!ERROR:logvaluer depth exceeded"
+	// instead.
+	MaxResolveDepth int
+
+	// ErrorWithType causes the Go type of an error value to
+	// be written in parentheses after its message, as in
+	// err="file not found (*fs.PathError)".
+	ErrorWithType bool
+
+	// ErrorChain causes an error value to be written as the
+	// messages of each error in its chain of Unwrap calls, joined by
+	// ": ", as in err="a: b: c", even when an error's own message does
+	// not include the message of the error it wraps. With
+	// ErrorWithType, each message is followed by the type of its error.
+	ErrorChain bool
+
+	// ErrorStackTrace causes an error value that records a stack trace
+	// to be followed by an attribute holding the trace. The key of the
+	// attribute is "stack" in a group named by the error's key, and its
+	// value is the frames of the trace separated by ", ", each written
+	// as FUNCTION@FILE:LINE with FILE shortened as determined by the
+	// SourceMode option. An error records a stack trace if it, or an
+	// error in its chain, implements
+	//
+	//	interface{ StackTrace() []uintptr }
+	ErrorStackTrace bool
+
+	// CanonicalJSON causes values formatted as JSON to be written in
+	// a canonical form, compact and with the keys of every object
+	// sorted, even when they are produced by a json.Marshaler that
+	// does not sort them, so that equal values are always written
+	// the same way. It cannot be combined with JSONIndent.
+	CanonicalJSON bool
+
+	// MarshalJSON, if non-nil, is used instead of the standard
+	// library to encode the values that are formatted as JSON, for
+	// example to use a faster encoder. The bytes it returns are
+	// written as a single value, quoted if they contain spaces, '=',
+	// control characters or other characters that require quoting.
+	// If it returns an error, the error is written instead, as for
+	// other formatting errors. The JSONIndent and CanonicalJSON
+	// options do not apply to its results.
+	MarshalJSON func(v any) ([]byte, error)
+
+	// AlwaysQuoteValues causes every value to be quoted, including
+	// those of the built-in attributes and values such as numbers,
+	// booleans and JSON that never need quoting, so that a parser
+	// need not decide whether a value is quoted. It overrides
+	// QuoteFunc and ValueQuoteFunc.
+	AlwaysQuoteValues bool
+
+	// AlwaysQuoteKeys causes every key to be quoted.
+	// It overrides QuoteFunc and KeyQuoteFunc.
+	AlwaysQuoteKeys bool
+
+	// ValueFormatters holds functions that format the values of
+	// particular attributes, keyed by the fully qualified key of the
+	// attribute, with group names separated as in the output, as in
+	// "req.latency". Each function appends the text for the value to
+	// the slice, and the handler quotes it afterwards if it needs
+	// quoting. The KeyUnits option does not apply to these values.
+	ValueFormatters map[string]func(slog.Value, *[]byte)
+
+	// MaxValueLen, if positive, limits the length in bytes of
+	// attribute values, not counting any quotes and escapes added
+	// when they are quoted. A longer value is truncated to at most
+	// MaxValueLen bytes, without splitting a UTF-8 sequence, and
+	// TruncationMarker is appended to it.
+	MaxValueLen int
+
+	// TruncationMarker is appended to values truncated because of
+	// MaxValueLen. If empty, "…" is used.
+	TruncationMarker string
+
+	// MaxRecordLen, if positive, limits the length in bytes of the
+	// line written for each record, not counting the line
+	// terminator. The attributes of a longer record are removed
+	// from the first one that does not fit onwards and replaced by
+	// "… (truncated)". The built-in attributes, such as the time,
+	// level and message, are never removed, so a record with a long
+	// message may still exceed the limit. Nor is the attribute added
+	// by AddDedupHash, for which room is left. MaxRecordLen applies
+	// only to LayoutText.
+	MaxRecordLen int
+
+	// OmitNil causes attributes whose value is nil to be omitted,
+	// after any ReplaceAttr function has been called. A value is nil
+	// if it is an untyped nil, as in slog.Any("x", nil), or a nil
+	// pointer of any type, including a nil *T stored in an error.
+	// Nil slices, maps and other nillable types are not omitted,
+	// because they are often used as empty values.
+	OmitNil bool
+
+	// BadKeyString, if non-empty, is used instead of "!BADKEY" as the
+	// key of attributes without a valid key, both those created by
+	// slog for arguments that are not part of a key-value pair and
+	// those renamed because of KeyMismatchRename.
+	BadKeyString string
+
+	// ErrorPrefix, if non-empty, is used instead of "!ERROR:" as
+	// the prefix of the error written in place of a value that cannot
+	// be formatted, in all layouts and in the JSONSidecar line.
+	ErrorPrefix string
+
+	// OnError, if non-nil, is called by Handle with the error
+	// whenever writing a record fails, including when the write
+	// times out. It is called once per failed write, after the
+	// handler's lock has been released, so it may itself log
+	// through the handler. For an [AsyncHandler], it is called
+	// from the goroutine that writes the records.
+	OnError func(error)
+
+	// WriteRetries, if positive, is the number of times a failed
+	// write is retried when RetryableError reports that its error is
+	// transient. Each retry writes only the bytes that have not yet
+	// been written, so a record is never duplicated by a partial
+	// write, but a record may then be split across several calls
+	// to Write. The handler's lock is held throughout.
+	WriteRetries int
+
+	// WriteBackoff is the delay before the first retry of a failed
+	// write. The delay doubles for each subsequent retry.
+	WriteBackoff time.Duration
+
+	// RetryableError reports whether a write that failed with the
+	// given error should be retried. If it is nil, only errors
+	// with a Timeout method that returns true, such as those from
+	// network connections, are retried.
+	RetryableError func(error) bool
+
+	// UseReaderFrom causes the handler to pass each record to the
+	// ReadFrom method of the writer, if it implements [io.ReaderFrom],
+	// instead of calling Write, so that the writer can control how
+	// the bytes are consumed. Each record still results in exactly
+	// one call, made with the handler's lock held, unless
+	// WriteRetries is set and the call fails.
+	UseReaderFrom bool
+
+	// LinePrefix, if non-empty, is written verbatim at the start of
+	// each line produced for a record, before the time, so that the
+	// output of several handlers can be told apart in one stream.
+	// It is not quoted, and it does not count towards MaxRecordLen.
+	LinePrefix string
+
+	// InitialBufferSize, if positive, is the minimum capacity of
+	// the buffer each record is formatted into. Setting it to the
+	// usual size of records avoids growing the buffer while
+	// formatting them. Buffers of up to this size are reused
+	// even if they are larger than the handler would otherwise keep.
+	InitialBufferSize int
+
+	// MaxPooledBufferSize, if positive, is the largest capacity of a
+	// buffer that the handler keeps for reuse after formatting a
+	// record into it. Larger buffers, grown for unusually large
+	// records, are discarded so that they do not hold on to memory.
+	// The default is 16KiB, or InitialBufferSize if that is larger.
+	// It must not be less than InitialBufferSize.
+	MaxPooledBufferSize int
+
+	// LogValuerGroupMode determines how a group returned by the
+	// LogValue method of a value is formatted. By default, it is
+	// formatted like any other group, with its key prefixed to the
+	// keys of its attributes.
+	//
+	// slog resolves the values of attributes added to a Record, and
+	// of those passed to Logger.With, before the handler sees them,
+	// so the mode applies only to LogValuers in attributes passed
+	// directly to WithAttrs, including those nested inside them.
+	LogValuerGroupMode LogValuerGroupMode
+
+	// Sequence causes the handler to add a sequence number to each
+	// record, with the key SequenceKey, immediately after the message.
+	// Records are numbered from 1, in the order in which they are
+	// formatted, by a counter shared by handlers derived from the
+	// same NewHandler call, so gaps reveal lost records. Records
+	// formatted concurrently may be written out of order. Each line
+	// written for a record split by ExplodeKey has its own number,
+	// so the numbers of those lines need not be consecutive.
+	Sequence bool
+
+	// IncludeHostname causes the handler to add the name of the host,
+	// as reported by [os.Hostname] when the handler is created, to
+	// each record with the key HostKey.
+	IncludeHostname bool
+
+	// IncludePID causes the handler to add the process ID to each
+	// record with the key PIDKey.
+	IncludePID bool
+
+	// QuoteEmpty causes empty string values to be written quoted,
+	// as in key="", so that they cannot be mistaken for missing values.
+	QuoteEmpty bool
+
+	// TimeLocation, if non-nil, is the location that times are
+	// converted to before they are formatted, both the built-in time,
+	// before any ReplaceAttr function sees it, and time values.
+	TimeLocation *time.Location
+
+	// TimeFormat determines how the built-in time and all time
+	// values are formatted when neither AppendTime nor TimeLayout
+	// is set.
+	TimeFormat TimeFormat
+
+	// AlignKeys, if positive, is the minimum width, in runes, of keys
+	// in the output. Shorter keys are followed, after the key-value
+	// separator, by enough spaces that the value starts where it
+	// would for a key of that width, so that values of consecutive
+	// lines line up when read by eye. The padding is best effort:
+	// widths are not measured in display columns, and they include
+	// any quotes or escapes. The output is not intended to be parsed.
+	AlignKeys int
+
+	// HexKeys holds the fully qualified keys, with group names
+	// separated as in the output, of attributes whose integer
+	// values are written in hexadecimal with a 0x prefix, as in
+	// addr=0xc000012345. Values of other kinds are unaffected.
+	// ValueFormatters takes precedence.
+	HexKeys map[string]bool
+
+	// ReplaceBuiltins holds functions that rewrite the values of
+	// the built-in attributes. Unlike ReplaceAttr, which they run
+	// before, they do not cause other attributes to be formatted
+	// more slowly.
+	ReplaceBuiltins BuiltinReplacers
+
+	// FlattenNewlines, if non-empty, replaces each line break in the
+	// message, whether "\n" or "\r\n", so that the message is kept on
+	// one line without the line breaks being escaped, as in
+	// msg=first␤second with a marker of "␤". The message is still
+	// quoted if it needs quoting for other reasons.
+	FlattenNewlines string
+
+	// FlattenValueNewlines causes FlattenNewlines to apply
+	// to string values as well as to the message.
+	FlattenValueNewlines bool
+
+	// StrictGroups causes the handler to check the group names
+	// passed to WithGroup. A name that contains the group separator
+	// (see GroupSeparator) or that would need quoting makes
+	// the keys that follow it ambiguous, so instead of silently
+	// concatenating it, the first record written by a handler
+	// using that group includes a diagnostic attribute with key
+	// BadGroupKey and a value starting with ErrorPrefix.
+	// The group is still used for the keys of its attributes.
+	//
+	// Only LayoutText writes the diagnostic; group attributes
+	// in records are not checked.
+	StrictGroups bool
+}
+
+// BuiltinReplacers holds functions that rewrite the built-in
+// attributes of records, for [Options.ReplaceBuiltins]. Any of
+// them may be nil.
+type BuiltinReplacers struct {
+	// Time rewrites the built-in time. If it returns
+	// the zero time, the time is omitted.
+	Time func(t time.Time) time.Time
+
+	// Level returns the text written for the level, instead of
+	// the label determined by LowercaseLevels and LevelLabels.
+	// If it returns the empty string, the level is omitted.
+	Level func(l slog.Level) string
+
+	// Source rewrites the source location, as formatted
+	// according to the source options. If it returns the
+	// empty string, the source is omitted. It is not called
+	// when SourceAsGroup is set.
+	Source func(source string) string
+
+	// Message rewrites the message.
+	Message func(msg string) string
+}
+
+// SchemaExtras determines what happens to attributes whose
+// keys are not in [Options.Schema].
+type SchemaExtras int
+
+const (
+	// SchemaExtrasDrop omits the attributes. This is the default.
+	SchemaExtrasDrop SchemaExtras = iota
+
+	// SchemaExtrasAppend writes the attributes after
+	// those in the schema.
+	SchemaExtrasAppend
+)
+
+// SourceMode determines how much of a source file's path is written.
+type SourceMode int
+
+const (
+	// SourceFull writes the full path. This is the default.
+	SourceFull SourceMode = iota
+
+	// SourceShort writes only the file's base name, as in handler.go:42.
+	SourceShort
+
+	// SourcePackage writes the name of the file's directory
+	// and its base name, as in slogtext/handler.go:42.
+	SourcePackage
+)
+
+// NonFiniteFloat determines how NaN and infinite floating-point
+// values are formatted. Unquoted, such values are not valid numbers
+// to many parsers.
+type NonFiniteFloat int
+
+const (
+	// NonFiniteQuoted writes the values as quoted strings:
+	// "NaN", "+Inf" and "-Inf". This is the default.
+	NonFiniteQuoted NonFiniteFloat = iota
+
+	// NonFiniteNull writes the values as null.
+	NonFiniteNull
+)
+
+// BytesEncoding determines how byte slice values are formatted.
+type BytesEncoding int
+
+const (
+	// BytesQuoted formats byte slices as Go quoted strings.
+	// This is the default.
+	BytesQuoted BytesEncoding = iota
+
+	// BytesBase64 formats byte slices using standard base64
+	// encoding. As the padding includes "=", the result
+	// is quoted when there is padding.
+	BytesBase64
+
+	// BytesHex formats byte slices as lower-case hexadecimal.
+	BytesHex
+)
+
+// LogValuerGroupMode determines how groups returned by
+// [slog.LogValuer] values are formatted.
+type LogValuerGroupMode int
+
+const (
+	// GroupPrefixed formats the group's attributes with the
+	// attribute's key as a prefix, as for other groups.
+	// This is the default.
+	GroupPrefixed LogValuerGroupMode = iota
+
+	// GroupInline formats the group's attributes without a
+	// prefix, as if they had been added alongside the attribute.
+	GroupInline
+
+	// GroupJSON formats the group as a single value holding
+	// a JSON object.
+	GroupJSON
+)
+
+// TimeFormat determines how times are formatted.
+type TimeFormat int
+
+const (
+	// TimeRFC3339Millis formats times in RFC3339 format with
+	// millisecond precision, as in 2000-01-02T03:04:05.000Z.
+	// This is the default.
+	TimeRFC3339Millis TimeFormat = iota
+
+	// TimeUnixSeconds formats times as an unquoted integer
+	// number of seconds since the Unix epoch, as in 946782245.
+	TimeUnixSeconds
+
+	// TimeUnixMillis formats times as an unquoted integer
+	// number of milliseconds since the Unix epoch.
+	TimeUnixMillis
+
+	// TimeUnixNanos formats times as an unquoted integer
+	// number of nanoseconds since the Unix epoch.
+	TimeUnixNanos
+)
+
+// DurationFormat determines how durations are formatted.
+type DurationFormat int
+
+const (
+	// DurationString formats durations with [time.Duration.String],
+	// as in 1m30s. This is the default.
+	DurationString DurationFormat = iota
+
+	// DurationSeconds formats durations as an unquoted
+	// decimal number of seconds, as in 90.
+	DurationSeconds
+
+	// DurationMillis formats durations as an unquoted
+	// decimal number of milliseconds, as in 90000.
+	DurationMillis
+
+	// DurationNanos formats durations as an unquoted
+	// integer number of nanoseconds, as in 90000000000.
+	DurationNanos
+
+	// DurationFloatMillis formats durations as a number of
+	// milliseconds formatted in the same way as a float64 value,
+	// as in 12.345 or 5.4e+06, for tracing backends that expect
+	// span durations in fractional milliseconds.
+	DurationFloatMillis
+)
+
+// KeyMismatchAction determines what happens to an attribute
+// whose key does not match [Options.KeyPattern].
+type KeyMismatchAction int
+
+const (
+	// KeyMismatchDrop omits the attribute.
+	KeyMismatchDrop KeyMismatchAction = iota
+	// KeyMismatchFlag emits the attribute with its key
+	// prefixed by "!".
+	KeyMismatchFlag
+	// KeyMismatchRename emits the attribute's value with
+	// the key "!BADKEY", or BadKeyString if it is set.
+	KeyMismatchRename
+)
+
+// badKey is the key used for attributes with an invalid key,
+// following the convention of the slog package.
+const badKey = "!BADKEY"
+
+// validate checks that the options are valid.
+func (opts *Options) validate() error {
+	for _, r := range opts.KVSeparator {
+		if r == '"' || r == '\\' || r == '\'' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return fmt.Errorf("invalid character %q in KVSeparator %q", r, opts.KVSeparator)
+		}
+	}
+	if q := opts.QuoteChar; q != 0 {
+		if q >= utf8.RuneSelf || q == '\\' || q == '=' || !unicode.IsPunct(rune(q)) && !unicode.IsSymbol(rune(q)) {
+			return fmt.Errorf("invalid QuoteChar %q", q)
+		}
+		if strings.IndexByte(opts.KVSeparator, q) >= 0 || strings.IndexByte(opts.FieldSeparator, q) >= 0 {
+			return fmt.Errorf("QuoteChar %q is used in a separator", q)
+		}
+	}
+	if opts.MaxPooledBufferSize > 0 && opts.MaxPooledBufferSize < opts.InitialBufferSize {
+		return fmt.Errorf("MaxPooledBufferSize %d is less than InitialBufferSize %d", opts.MaxPooledBufferSize, opts.InitialBufferSize)
+	}
+	if opts.CanonicalJSON && opts.JSONIndent != "" {
+		return fmt.Errorf("CanonicalJSON cannot be used with JSONIndent")
+	}
+	if opts.JSONSidecar && opts.NoNewline && opts.LineTerminator == "" {
+		return fmt.Errorf("JSONSidecar requires a line terminator but NoNewline is set")
 	}
+	if opts.WriteRetries < 0 {
+		return fmt.Errorf("negative WriteRetries %d", opts.WriteRetries)
+	}
+	for _, rule := range opts.TagRules {
+		if rule.Match == nil {
+			return fmt.Errorf("TagRules rule for tag %q has no Match function", rule.Tag)
+		}
+	}
+	return nil
+}
+
+// SampleEvery returns a function suitable for [Options.Sample]
+// that keeps one in every n records for each level in the map.
+// Levels not in the map, or with n <= 1, are always kept.
+// The map is copied, so later changes to it have no effect.
+// The returned function is safe for concurrent use.
+func SampleEvery(n map[slog.Level]int) func(slog.Level) bool {
+	type sampler struct {
+		every uint64
+		count atomic.Uint64
+	}
+	samplers := make(map[slog.Level]*sampler, len(n))
+	for l, every := range n {
+		if every > 1 {
+			samplers[l] = &sampler{every: uint64(every)}
+		}
+	}
+	return func(l slog.Level) bool {
+		s := samplers[l]
+		if s == nil {
+			return true
+		}
+		return (s.count.Add(1)-1)%s.every == 0
+	}
+}
+
+// NewHandlerWithOptions returns a Handler that writes to w
+// using the given options. It panics if the options are invalid.
+func NewHandlerWithOptions(w io.Writer, opts Options) *Handler {
+	if err := opts.validate(); err != nil {
+		panic("slogtext: " + err.Error())
+	}
+	if opts.Level == nil {
+		// Allow the level to be changed with SetLevel.
+		opts.Level = new(slog.LevelVar)
+	}
+	h := &Handler{
+		w:             w,
+		opts:          opts,
+		mu:            new(sync.Mutex),
+		color:         useColor(opts.Color, w),
+		ditto:         newDittoState(opts.DittoRepeatedAttrs),
+		headerWritten: new(bool),
+	}
+	if opts.EmitSummaryOnClose {
+		h.stats = newRecordStats()
+	}
+	h.stalled = new(atomic.Bool)
+	if opts.SelfDescribing {
+		h.descriptor = opts.descriptor()
+		h.described = new(bool)
+	}
+	if opts.Locale != language.Und {
+		h.printer = message.NewPrinter(opts.Locale)
+	}
+	if opts.Sequence {
+		h.seq = new(atomic.Uint64)
+	}
+	if opts.IncludeHostname {
+		// If the host name is unknown, it is omitted.
+		h.hostname, _ = os.Hostname()
+	}
+	if opts.IncludePID {
+		h.pid = os.Getpid()
+	}
+	if opts.InitialBufferSize > 0 || opts.MaxPooledBufferSize > 0 {
+		maxSize := opts.MaxPooledBufferSize
+		if maxSize <= 0 {
+			maxSize = max(maxBufferSize, opts.InitialBufferSize)
+		}
+		size := min(max(initialBufferSize, opts.InitialBufferSize), maxSize)
+		h.bufPool = newBufferPool(size, maxSize)
+	}
+	return h
+}
+
+func NewHandlerWithOpts(w io.Writer, opts slog.HandlerOptions) *Handler {
+	return NewHandlerWithOptions(w, Options{HandlerOptions: opts})
 }
 
 func NewHandler(w io.Writer) *Handler {
@@ -33,9 +1035,36 @@ func NewHandler(w io.Writer) *Handler {
 }
 
 // Enabled reports whether the handler handles records at the given level.
-// The handler ignores records whose level is lower.
-func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
-	return h.enabled(level)
+// The handler ignores records whose level is lower, and records
+// rejected by [Options.Sample]. The minimum level is taken from
+// [Options.LevelFromContext] if it provides one for ctx.
+//
+// When Sample is set, Enabled has a side effect: each call
+// consumes a sampling decision.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if !h.enabled(ctx, level) {
+		return false
+	}
+	return h.opts.Sample == nil || h.opts.Sample(level)
+}
+
+// SetLevel sets the minimum level of records that h handles.
+// The change applies to h and to all handlers derived from it.
+// It panics if the Level option was set to something other than
+// a [*slog.LevelVar]; if the option was not set, the handler
+// has a LevelVar of its own.
+func (h *Handler) SetLevel(l slog.Level) {
+	v, ok := h.opts.Level.(*slog.LevelVar)
+	if !ok {
+		panic(fmt.Sprintf("slogtext: SetLevel called on handler with Level of type %T", h.opts.Level))
+	}
+	v.Set(l)
+}
+
+// Level returns the minimum level of records that h handles,
+// not taking [Options.LevelFromContext] into account.
+func (h *Handler) Level() slog.Level {
+	return h.opts.Level.Level()
 }
 
 // WithAttrs returns a new Handler whose attributes consists
@@ -48,27 +1077,83 @@ func (h *Handler) WithGroup(name string) slog.Handler {
 	return h.withGroup(name)
 }
 
+// WithWriter returns a new Handler that is the same as h, with the
+// same preformatted attributes and groups, but writes to w. The new
+// handler shares no mutable state with h: it has its own lock and,
+// if the relevant options are set, its own ditto state and record
+// counts. Calls to Handle on h, including those in progress, are
+// unaffected. The new handler does share h's Level option, so
+// SetLevel on either of them changes the level of both.
+func (h *Handler) WithWriter(w io.Writer) *Handler {
+	h2 := h.clone()
+	h2.w = w
+	h2.mu = new(sync.Mutex)
+	h2.color = useColor(h.opts.Color, w)
+	h2.ditto = newDittoState(h.opts.DittoRepeatedAttrs)
+	h2.stalled = new(atomic.Bool)
+	h2.headerWritten = new(bool)
+	if h.described != nil {
+		h2.described = new(bool)
+	}
+	if h.stats != nil {
+		h2.stats = newRecordStats()
+	}
+	if h.seq != nil {
+		h2.seq = new(atomic.Uint64)
+	}
+	return h2
+}
+
+// Reset removes the attributes and groups added to h with WithAttrs
+// and WithGroup, returning it to the state in which it was
+// created, so that it can be reused. Handlers derived from h
+// are unaffected, as is the state h shares with them, such as
+// the sequence counter, the record counts kept for
+// EmitSummaryOnClose and the state used by DittoRepeatedAttrs.
+//
+// Reset does no locking: the fields it clears are read without a
+// lock when records are formatted, so it must not be called
+// concurrently with logging through h or with any other method on h.
+func (h *Handler) Reset() {
+	h.preformattedAttrs = nil
+	h.preformattedFields = nil
+	h.groupPrefix = ""
+	h.groups = nil
+	h.nOpenGroups = 0
+	h.goas = nil
+	h.badGroups = nil
+}
+
 // Handle formats its argument Record as a single line of space-separated
 // key=value items.
 //
 // If the Record's time is zero, the time is omitted.
 // Otherwise, the key is "time"
-// and the value is output in RFC3339 format with millisecond precision.
+// and the value is output in RFC3339 format with millisecond precision,
+// unless the TimeLayout or AppendTime options specify otherwise.
 //
 // If the Record's level is zero, the level is omitted.
 // Otherwise, the key is "level"
 // and the value of [Level.String] is output.
 //
-// If the AddSource option is set and source information is available,
-// the key is "source" and the value is output as FILE:LINE.
+// If the AddSource option is set, or the SourceLevel option applies,
+// and source information is available,
+// the key is "source" and the value is output as FILE:LINE,
+// with FILE shortened as determined by the SourceMode option
+// and preceded by FUNCTION@ if the SourceWithFunction option is set,
+// or as FUNCTION:LINE if the SourcePrivacy option is set.
 //
 // The message's key is "msg".
 //
+// If the AddRequestID option is set and the context holds a request ID,
+// the key is "request_id" and the ID follows the message.
+//
 // To modify these or other attributes, or remove them from the output, use
 // [HandlerOptions.ReplaceAttr].
 //
 // If a value implements [encoding.TextMarshaler], the result of MarshalText is
-// written.
+// written. Otherwise, if a value implements error, the result of Error is
+// written, modified as determined by the ErrorWithType and ErrorChain options.
 //
 // For values with a directly supported kind (all [slog.Kind] kinds except
 // KindAny), the value is formatted as with [fmt.Sprint], with keys and values
@@ -89,19 +1174,88 @@ func (h *Handler) WithGroup(name string) slog.Handler {
 // [HandlerOptions.ReplaceAttr] to encode that information in the key.
 //
 // Each call to Handle results in a single serialized call to
-// io.Writer.Write.
-func (h *Handler) Handle(_ context.Context, r slog.Record) error {
-	return h.handle(r)
+// io.Writer.Write. See [Handler.WriterFor] for details.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	_, err := h.handle(ctx, r)
+	return err
+}
+
+// HandleN is like [Handler.Handle] but also returns the number of
+// bytes written by the call to Write, including the line terminator.
+// It returns 0 if the record is discarded.
+func (h *Handler) HandleN(ctx context.Context, r slog.Record) (int, error) {
+	return h.handle(ctx, r)
+}
+
+// AppendRecord appends r to dst, formatted exactly as Handle would
+// write it, and returns the extended buffer. It does not write to
+// the handler's writer or take its lock, so many records can be
+// gathered into one buffer before writing them together. Unlike
+// Handle, it never writes the SelfDescribing descriptor line.
+// It does not allocate for small records if dst has enough capacity.
+func (h *Handler) AppendRecord(dst []byte, ctx context.Context, r slog.Record) ([]byte, error) {
+	// Borrow a pooled buffer to hold dst so that
+	// the buffer passed to format does not escape.
+	buf := newBuffer()
+	saved := *buf
+	*buf = dst
+	h.format(buf, ctx, r)
+	dst = *buf
+	*buf = saved
+	buf.Free()
+	return dst, nil
+}
+
+// WriterFor returns the writer that records at the given level
+// are written to.
+//
+// The handler formats each record completely before writing it with
+// exactly one call to Write, so a record is never split across calls,
+// unless the WriteRetries option is set and a call fails, in which case
+// the rest of the record is written by further calls.
+// Calls to Write are serialized by a lock shared by h and all handlers
+// derived from it with WithAttrs and WithGroup, and Write is never
+// called concurrently by them. The slice passed to Write must not
+// be retained after it returns.
+//
+// This means that a writer that rotates log files can safely
+// switch to a new file between calls to Write without further
+// coordination with the handler, as long as it is not also
+// used by other, independently constructed handlers and
+// WriteRetries is not set.
+func (h *Handler) WriterFor(level slog.Level) io.Writer {
+	return h.writerFor(level)
 }
 
 func appendTextValue(s *handleState, v slog.Value) error {
+	if v.Kind() == slog.KindFloat64 {
+		if f := v.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			s.appendNonFinite(f)
+			return nil
+		}
+	}
+	if s.h.printer != nil && s.appendLocalNumber(v) {
+		return nil
+	}
 	switch v.Kind() {
 	case slog.KindString:
-		s.appendString(v.String())
+		str := v.String()
+		if s.h.opts.FlattenValueNewlines {
+			str = s.h.flattenNewlines(str)
+		}
+		s.appendString(str)
 	case slog.KindTime:
 		s.appendTime(v.Time())
 	case slog.KindAny, slog.KindLogValuer:
 		x := v.Any()
+		if s.h.opts.GoSyntaxValues {
+			s.appendString(fmt.Sprintf("%#v", x))
+			return nil
+		}
+		if s.h.opts.DistinguishEmptyContainers && isNil(x) {
+			s.buf.WriteString("null")
+			return nil
+		}
 		if tm, ok := x.(encoding.TextMarshaler); ok {
 			data, err := tm.MarshalText()
 			if err != nil {
@@ -111,26 +1265,68 @@ func appendTextValue(s *handleState, v slog.Value) error {
 			s.appendString(string(data))
 			return nil
 		}
+		if err, ok := x.(error); ok && !isNilPointer(x) {
+			// A nil pointer's Error method is likely to panic.
+			s.appendErrorValue(err)
+			return nil
+		}
 		if bs, ok := byteSlice(x); ok {
-			// As of Go 1.19, this only allocates for strings longer than 32 bytes.
-			s.buf.WriteString(strconv.Quote(string(bs)))
+			s.appendBytes(bs, x)
+			return nil
+		}
+		if s.h.opts.MaxJSONDepth > 0 {
+			if err := checkJSONDepth(x, s.h.opts.MaxJSONDepth); err != nil {
+				return err
+			}
+		}
+		start := len(*s.buf)
+		if f := s.h.opts.MarshalJSON; f != nil {
+			data, err := f(x)
+			if err != nil {
+				return err
+			}
+			s.buf.Write(data)
+			s.quoteFrom(start)
 			return nil
 		}
-		data, err := appendJSONMarshal(x, *s.buf)
+		marshal := s.jsonEncoder().appendMarshal
+		if s.h.opts.CanonicalJSON {
+			marshal = s.jsonEncoder().appendCanonical
+		}
+		data, err := marshal(x, *s.buf)
 		if err != nil {
 			return err
 		}
 		*s.buf = data
+		if s.h.opts.JSONIndent != "" {
+			s.formatIndentedJSON(start)
+		}
 	case slog.KindInt64:
 		*s.buf = strconv.AppendInt(*s.buf, v.Int64(), 10)
 	case slog.KindUint64:
 		*s.buf = strconv.AppendUint(*s.buf, v.Uint64(), 10)
 	case slog.KindFloat64:
-		*s.buf = strconv.AppendFloat(*s.buf, v.Float64(), 'g', -1, 64)
+		*s.buf = appendFloat(*s.buf, v.Float64())
 	case slog.KindBool:
 		*s.buf = strconv.AppendBool(*s.buf, v.Bool())
 	case slog.KindDuration:
-		*s.buf = append(*s.buf, v.Duration().String()...)
+		d := v.Duration()
+		switch s.h.opts.DurationFormat {
+		case DurationSeconds:
+			*s.buf = strconv.AppendFloat(*s.buf, d.Seconds(), 'f', -1, 64)
+		case DurationMillis:
+			*s.buf = strconv.AppendFloat(*s.buf, float64(d)/float64(time.Millisecond), 'f', -1, 64)
+		case DurationNanos:
+			*s.buf = strconv.AppendInt(*s.buf, int64(d), 10)
+		case DurationFloatMillis:
+			*s.buf = appendFloat(*s.buf, float64(d)/float64(time.Millisecond))
+		default:
+			if s.h.opts.DurationCompact {
+				*s.buf = appendCompactDuration(*s.buf, d)
+			} else {
+				*s.buf = append(*s.buf, d.String()...)
+			}
+		}
 	case slog.KindGroup:
 		*s.buf = fmt.Append(*s.buf, v.Group())
 	default:
@@ -139,6 +1335,136 @@ func appendTextValue(s *handleState, v slog.Value) error {
 	return nil
 }
 
+// JSONContinuation is written at the start of each continuation line
+// of a value that spans several lines under [Options.MultilineJSON].
+const JSONContinuation = "| "
+
+// formatIndentedJSON arranges the indented JSON in the buffer
+// from start onwards to be written as determined by the MultilineJSON
+// option: either quoted as a single value, or with each line after the
+// first preceded by JSONContinuation.
+func (s *handleState) formatIndentedJSON(start int) {
+	if !s.h.opts.MultilineJSON {
+		s.quoteFrom(start)
+		return
+	}
+	lines := bytes.Count((*s.buf)[start:], []byte("\n"))
+	if lines == 0 {
+		return
+	}
+	// Work from the end so that the marker insertions
+	// don't move the bytes still to be examined.
+	end := len(*s.buf)
+	*s.buf = slices.Grow(*s.buf, lines*len(JSONContinuation))[:end+lines*len(JSONContinuation)]
+	b := *s.buf
+	w := len(b)
+	for r := end - 1; r >= start; r-- {
+		if b[r] == '\n' {
+			w -= len(JSONContinuation)
+			copy(b[w:], JSONContinuation)
+		}
+		w--
+		b[w] = b[r]
+	}
+}
+
+// appendNonFinite appends the NaN or infinite value f
+// as determined by the NonFiniteFloat option.
+func (s *handleState) appendNonFinite(f float64) {
+	if s.h.opts.NonFiniteFloat == NonFiniteNull {
+		s.buf.WriteString("null")
+		return
+	}
+	str := "NaN"
+	switch {
+	case math.IsInf(f, 1):
+		str = "+Inf"
+	case math.IsInf(f, -1):
+		str = "-Inf"
+	}
+	*s.buf = s.appendQuoted(*s.buf, str)
+}
+
+// appendBytes appends the byte slice bs, whose original value is x,
+// in the form chosen by the BytesEncoding option.
+// A json.RawMessage holding valid JSON is written as is, quoted only if
+// it contains white space or '='. Otherwise it is always quoted.
+func (s *handleState) appendBytes(bs []byte, x any) {
+	start := len(*s.buf)
+	enc := s.h.opts.BytesEncoding
+	if _, ok := x.(json.RawMessage); ok {
+		if json.Valid(bs) && !bytes.ContainsFunc(bs, func(r rune) bool {
+			return r == '=' || unicode.IsSpace(r)
+		}) {
+			s.buf.Write(bs)
+			return
+		}
+		enc = BytesQuoted
+	}
+	switch enc {
+	case BytesBase64:
+		n := base64.StdEncoding.EncodedLen(len(bs))
+		*s.buf = slices.Grow(*s.buf, n)[:start+n]
+		base64.StdEncoding.Encode((*s.buf)[start:], bs)
+	case BytesHex:
+		n := hex.EncodedLen(len(bs))
+		*s.buf = slices.Grow(*s.buf, n)[:start+n]
+		hex.Encode((*s.buf)[start:], bs)
+	default:
+		if s.h.opts.QuoteChar != 0 {
+			*s.buf = s.appendQuoted(*s.buf, string(bs))
+			return
+		}
+		// As of Go 1.19, this only allocates for strings longer than 32 bytes.
+		s.buf.WriteString(strconv.Quote(string(bs)))
+		return
+	}
+	s.quoteFrom(start)
+}
+
+// appendFloat appends the text form of a float64 value to dst.
+func appendFloat(dst []byte, f float64) []byte {
+	return strconv.AppendFloat(dst, f, 'g', -1, 64)
+}
+
+// appendCompactDuration appends d.String() to dst with any
+// trailing zero minutes and seconds removed.
+func appendCompactDuration(dst []byte, d time.Duration) []byte {
+	start := len(dst)
+	dst = append(dst, d.String()...)
+	b := dst[start:]
+	// Only durations of a minute or more have an "m" or "h"
+	// component before the seconds; anything else, such as
+	// "0s" or "1.5ms", is left alone.
+	if len(b) > 3 && bytes.HasSuffix(b, []byte("m0s")) {
+		b = b[:len(b)-2]
+		if len(b) > 2 && bytes.HasSuffix(b, []byte("h0m")) {
+			b = b[:len(b)-2]
+		}
+	}
+	return dst[:start+len(b)]
+}
+
+// JoinGroups returns the fully qualified key for an attribute
+// with the given key inside the given groups, joining the components
+// with sep in the same way as the handler does. This is useful
+// inside a [slog.HandlerOptions.ReplaceAttr] function, which
+// is passed the groups and key separately. The handler itself
+// uses "." as the separator unless [Options.GroupSeparator] is set.
+func JoinGroups(groups []string, key string, sep string) string {
+	n := len(key)
+	for _, g := range groups {
+		n += len(g) + len(sep)
+	}
+	buf := make([]byte, 0, n)
+	for _, g := range groups {
+		buf = append(buf, g...)
+		buf = append(buf, sep...)
+	}
+	buf = append(buf, key...)
+	return string(buf)
+}
+
 // byteSlice returns its argument as a []byte if the argument's
 // underlying type is []byte, along with a second return value of true.
 // Otherwise it returns nil, false.
@@ -154,13 +1480,58 @@ func byteSlice(a any) ([]byte, bool) {
 	return nil, false
 }
 
+// isNil reports whether a is nil or holds a nil value
+// of a nillable type.
+func isNil(a any) bool {
+	if a == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(a); v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNilPointer reports whether a is nil or holds a nil pointer.
+func isNilPointer(a any) bool {
+	if a == nil {
+		return true
+	}
+	v := reflect.ValueOf(a)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// NeedsQuoting reports whether the handler quotes s when writing
+// it as a key or value under the default rules: that is, when s
+// contains a space, '=', a quote, a control character or
+// a non-printable or invalid character. It does not take into
+// account options that change quoting, such as QuoteFunc,
+// QuoteEmpty or KVSeparator.
+func NeedsQuoting(s string) bool {
+	return needsQuoting(s)
+}
+
 func needsQuoting(s string) bool {
+	return quotingRequired(s, true)
+}
+
+// needsQuotingSep is like needsQuoting except that
+// it treats sep, rather than '=', as the key/value
+// separator that requires quoting.
+func needsQuotingSep(s, sep string) bool {
+	return quotingRequired(s, false) || strings.Contains(s, sep)
+}
+
+// quotingRequired reports whether s needs quoting,
+// treating '=' as requiring it only if quoteEquals is true.
+func quotingRequired(s string, quoteEquals bool) bool {
 	for i := 0; i < len(s); {
 		b := s[i]
 		if b < utf8.RuneSelf {
 			// Quote anything except a backslash that would need quoting in a
-			// JSON string, as well as space and '='
-			if b != '\\' && (b == ' ' || b == '=' || !safeSet[b]) {
+			// JSON string, as well as space and (if quoteEquals) '='
+			if b != '\\' && (b == ' ' || (b == '=' && quoteEquals) || !safeSet[b]) {
 				return true
 			}
 			i++
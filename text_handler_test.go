@@ -7,11 +7,16 @@ package slogtext
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"golang.org/x/exp/slog"
+	"math"
+	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -135,13 +140,146 @@ func TestHandlerSource(t *testing.T) {
 	}
 }
 
-var sourceRegexp = regexp.MustCompile(`source="?([A-Z]:)?[^:]+text_handler_test\.go:\d+"? msg`)
+func TestSourceLevel(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{SourceLevel: slog.LevelError})
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		buf.Reset()
+		r := slog.NewRecord(testTime, level, "m", callerPC(2))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		if want := level >= slog.LevelError; sourceRegexp.MatchString(got) != want {
+			t.Errorf("level %v: got %q, want source %t", level, got, want)
+		}
+	}
+}
+
+var sourceRegexp = regexp.MustCompile(`source="?([^@ "]+@)?([A-Z]:)?[^:]+text_handler_test\.go:\d+"? msg`)
+
+func TestSourceWithFunction(t *testing.T) {
+	for _, replace := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := Options{SourceWithFunction: true, SourceMode: SourceShort}
+		opts.AddSource = true
+		if replace {
+			opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+		}
+		h := NewHandlerWithOptions(&buf, opts)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", callerPC(2))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		re := regexp.MustCompile(`^level=INFO source=slogtext\.TestSourceWithFunction@text_handler_test\.go:\d+ msg=m\n$`)
+		if !re.MatchString(got) || !sourceRegexp.MatchString(got) {
+			t.Errorf("replace %t: got %q", replace, got)
+		}
+	}
+}
+
+func TestSourceAsGroup(t *testing.T) {
+	var gotGroups [][]string
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "off",
+			opts: Options{SourceAsGroup: true},
+			want: `^level=INFO msg=m\n$`,
+		},
+		{
+			name: "grouped",
+			opts: Options{
+				HandlerOptions: slog.HandlerOptions{AddSource: true},
+				SourceAsGroup:  true,
+				SourceMode:     SourceShort,
+			},
+			want: `^level=INFO source\.file=text_handler_test\.go source\.line=\d+ source\.function=slogtext\.TestSourceAsGroup\.func\d+ msg=m\n$`,
+		},
+		{
+			name: "ReplaceAttr",
+			opts: Options{
+				HandlerOptions: slog.HandlerOptions{
+					AddSource: true,
+					ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+						gotGroups = append(gotGroups, slices.Clone(groups))
+						if a.Key == "function" {
+							return slog.Attr{}
+						}
+						return a
+					},
+				},
+				SourceAsGroup: true,
+				SourceMode:    SourceShort,
+			},
+			want: `^time=\S+ level=INFO source\.file=text_handler_test\.go source\.line=\d+ msg=m\n$`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			gotGroups = nil
+			var buf bytes.Buffer
+			tm := time.Time{}
+			if test.opts.ReplaceAttr != nil {
+				tm = testTime
+			}
+			h := NewHandlerWithOptions(&buf, test.opts)
+			if err := h.WithGroup("g").Handle(context.Background(), slog.NewRecord(tm, slog.LevelInfo, "m", callerPC(2))); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); !regexp.MustCompile(test.want).MatchString(got) {
+				t.Errorf("got %q, want match for %s", got, test.want)
+			}
+		})
+	}
+	want := [][]string{nil, nil, {"source"}, {"source"}, {"source"}, nil}
+	if fmt.Sprint(gotGroups) != fmt.Sprint(want) {
+		t.Errorf("ReplaceAttr saw groups %q, want %q", gotGroups, want)
+	}
+}
+
+func TestSourcePrivacy(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"plain", Options{SourcePrivacy: true}, `^level=INFO source=slogtext\.TestSourcePrivacy:\d+ msg=m\n$`},
+		{"overrides other options", Options{SourcePrivacy: true, SourceWithFunction: true, SourceMode: SourcePackage}, `^level=INFO source=slogtext\.TestSourcePrivacy:\d+ msg=m\n$`},
+		{"ReplaceAttr", Options{SourcePrivacy: true, HandlerOptions: slog.HandlerOptions{ReplaceAttr: removeKeys(slog.TimeKey)}}, `^level=INFO source=slogtext\.TestSourcePrivacy:\d+ msg=m\n$`},
+		{"group", Options{SourcePrivacy: true, SourceAsGroup: true}, `^level=INFO source\.line=\d+ source\.function=slogtext\.TestSourcePrivacy msg=m\n$`},
+	} {
+		var buf bytes.Buffer
+		opts := test.opts
+		opts.AddSource = true
+		for _, layout := range []Layout{LayoutText, LayoutProtoText} {
+			buf.Reset()
+			opts.Layout = layout
+			h := NewHandlerWithOptions(&buf, opts)
+			if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", callerPC(2))); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.String()
+			if strings.Contains(got, "text_handler_test.go") || strings.Contains(got, "/") {
+				t.Errorf("%s, layout %d: output contains a path: %q", test.name, layout, got)
+			}
+			if layout == LayoutText && !regexp.MustCompile(test.want).MatchString(got) {
+				t.Errorf("%s: got %q, want match for %s", test.name, got, test.want)
+			}
+		}
+	}
+}
 
 func TestSourceRegexp(t *testing.T) {
 	for _, s := range []string{
 		`source=/tmp/path/to/text_handler_test.go:23 msg=m`,
 		`source=C:\windows\path\text_handler_test.go:23 msg=m"`,
 		`source="/tmp/tmp.XcGZ9cG9Xb/with spaces/exp/slog/text_handler_test.go:95" msg=m`,
+		`source=slogtext.TestHandlerSource@/tmp/path/to/text_handler_test.go:23 msg=m`,
+		`source="slogtext.(*T).M@/tmp/with spaces/text_handler_test.go:23" msg=m`,
 	} {
 		if !sourceRegexp.MatchString(s) {
 			t.Errorf("failed to match %s", s)
@@ -149,20 +287,114 @@ func TestSourceRegexp(t *testing.T) {
 	}
 }
 
+func TestTrimSourcePath(t *testing.T) {
+	for _, test := range []struct {
+		file       string
+		short, pkg string
+	}{
+		{"/tmp/path/to/text_handler_test.go", "text_handler_test.go", "to/text_handler_test.go"},
+		{`C:\windows\path\text_handler_test.go`, "text_handler_test.go", `path\text_handler_test.go`},
+		{"/text_handler_test.go", "text_handler_test.go", "/text_handler_test.go"},
+		{"dir/text_handler_test.go", "text_handler_test.go", "dir/text_handler_test.go"},
+		{"text_handler_test.go", "text_handler_test.go", "text_handler_test.go"},
+	} {
+		if got := trimSourcePath(test.file, SourceFull); got != test.file {
+			t.Errorf("full %q: got %q", test.file, got)
+		}
+		if got := trimSourcePath(test.file, SourceShort); got != test.short {
+			t.Errorf("short %q: got %q, want %q", test.file, got, test.short)
+		}
+		if got := trimSourcePath(test.file, SourcePackage); got != test.pkg {
+			t.Errorf("package %q: got %q, want %q", test.file, got, test.pkg)
+		}
+	}
+}
+
+func TestSourceMode(t *testing.T) {
+	for _, test := range []struct {
+		mode    SourceMode
+		replace bool
+		want    string
+	}{
+		{SourceShort, false, `^level=INFO source=text_handler_test\.go:\d+ msg=m\n$`},
+		{SourceShort, true, `^level=INFO source=text_handler_test\.go:\d+ msg=m\n$`},
+		{SourcePackage, false, `^level=INFO source="?[^/\\ ]+[/\\]text_handler_test\.go:\d+"? msg=m\n$`},
+		{SourcePackage, true, `^level=INFO source="?[^/\\ ]+[/\\]text_handler_test\.go:\d+"? msg=m\n$`},
+	} {
+		var buf bytes.Buffer
+		opts := Options{SourceMode: test.mode}
+		opts.AddSource = true
+		if test.replace {
+			opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+		}
+		h := NewHandlerWithOptions(&buf, opts)
+		if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", callerPC(2))); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); !regexp.MustCompile(test.want).MatchString(got) {
+			t.Errorf("mode %d, replace %t: got %q, want match for %s", test.mode, test.replace, got, test.want)
+		}
+	}
+}
+
 func TestHandlerPreformatted(t *testing.T) {
-	var buf bytes.Buffer
-	var h slog.Handler = NewHandler(&buf)
-	h = h.WithAttrs([]slog.Attr{slog.Duration("dur", time.Minute), slog.Bool("b", true)})
-	// Also test omitting time.
-	r := slog.NewRecord(time.Time{}, 0 /* 0 Level is INFO */, "m", 0)
-	r.AddAttrs(slog.Int("a", 1))
-	if err := h.Handle(context.Background(), r); err != nil {
-		t.Fatal(err)
+	for _, test := range []struct {
+		format DurationFormat
+		want   string
+	}{
+		{DurationString, `level=INFO msg=m dur=1m0s b=true a=1`},
+		{DurationSeconds, `level=INFO msg=m dur=60 b=true a=1`},
+		{DurationMillis, `level=INFO msg=m dur=60000 b=true a=1`},
+		{DurationNanos, `level=INFO msg=m dur=60000000000 b=true a=1`},
+	} {
+		var buf bytes.Buffer
+		var h slog.Handler = NewHandlerWithOptions(&buf, Options{DurationFormat: test.format})
+		h = h.WithAttrs([]slog.Attr{slog.Duration("dur", time.Minute), slog.Bool("b", true)})
+		// Also test omitting time.
+		r := slog.NewRecord(time.Time{}, 0 /* 0 Level is INFO */, "m", 0)
+		r.AddAttrs(slog.Int("a", 1))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		got := strings.TrimSuffix(buf.String(), "\n")
+		if got != test.want {
+			t.Errorf("format %d: got %s, want %s", test.format, got, test.want)
+		}
 	}
-	got := strings.TrimSuffix(buf.String(), "\n")
-	want := `level=INFO msg=m dur=1m0s b=true a=1`
-	if got != want {
-		t.Errorf("got %s, want %s", got, want)
+}
+
+func TestDurationFormat(t *testing.T) {
+	durations := []time.Duration{
+		1500 * time.Microsecond,
+		-2500 * time.Millisecond,
+		time.Nanosecond,
+		90 * time.Minute,
+	}
+	for _, test := range []struct {
+		format DurationFormat
+		want   string
+	}{
+		{DurationString, `d=1.5ms d=-2.5s d=1ns d=1h30m0s`},
+		{DurationSeconds, `d=0.0015 d=-2.5 d=0.000000001 d=5400`},
+		{DurationMillis, `d=1.5 d=-2500 d=0.000001 d=5400000`},
+		{DurationNanos, `d=1500000 d=-2500000000 d=1 d=5400000000000`},
+		{DurationFloatMillis, `d=1.5 d=-2500 d=1e-06 d=5.4e+06 d=0.012345 d=12.345678`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{DurationFormat: test.format})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		for _, d := range durations {
+			r.AddAttrs(slog.Duration("d", d))
+		}
+		if test.format == DurationFloatMillis {
+			r.AddAttrs(slog.Duration("d", 12345*time.Nanosecond), slog.Duration("d", 12345678*time.Nanosecond))
+		}
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("format %d: got %q, want %q", test.format, got, want)
+		}
 	}
 }
 
@@ -179,6 +411,123 @@ func TestHandlerAlloc(t *testing.T) {
 	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
 }
 
+func TestHandlerAllocInitialBufferSize(t *testing.T) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.Int("x", 1))
+	h := NewHandlerWithOptions(io.Discard, Options{InitialBufferSize: 4096})
+	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
+
+	// Records larger than the usual pooled buffers are
+	// formatted without allocating when they fit.
+	r.AddAttrs(slog.String("big", strings.Repeat("x", 20<<10)))
+	h = NewHandlerWithOptions(io.Discard, Options{InitialBufferSize: 32 << 10})
+	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
+}
+
+func TestAppendRecord(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{HandlerOptions: slog.HandlerOptions{AddSource: true}}).WithGroup("g").WithAttrs([]slog.Attr{slog.Int("a", 1)}).(*Handler)
+	ctx := context.Background()
+	var dst []byte
+	for i := 0; i < 3; i++ {
+		r := slog.NewRecord(testTime, slog.LevelInfo, "hello", callerPC(2))
+		r.AddAttrs(slog.Int("i", i), slog.String("s", "x y"), slog.Any("m", map[string]int{"k": i}))
+		if err := h.Handle(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		dst, err = h.AppendRecord(dst, ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := string(dst), buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	for i := 0; i < 10; i++ {
+		r.AddAttrs(slog.Int("x = y", i))
+	}
+	h = NewHandler(io.Discard)
+	dst = make([]byte, 0, 4096)
+	wantAllocs(t, 0, func() { h.AppendRecord(dst, ctx, r) })
+}
+
+func TestSetLevel(t *testing.T) {
+	ctx := context.Background()
+	h := NewHandler(io.Discard)
+	h2 := h.WithGroup("g").(*Handler)
+	if got := h.Level(); got != slog.LevelInfo {
+		t.Errorf("got initial level %v, want INFO", got)
+	}
+	for _, l := range []slog.Level{slog.LevelDebug, slog.LevelError, slog.LevelDebug} {
+		h.SetLevel(l)
+		for _, h := range []*Handler{h, h2} {
+			if got := h.Level(); got != l {
+				t.Errorf("got level %v, want %v", got, l)
+			}
+			if got, want := h.Enabled(ctx, slog.LevelDebug), l == slog.LevelDebug; got != want {
+				t.Errorf("level %v: Enabled(DEBUG) = %t, want %t", l, got, want)
+			}
+			if !h.Enabled(ctx, slog.LevelError) {
+				t.Errorf("level %v: ERROR not enabled", l)
+			}
+		}
+	}
+
+	// An existing LevelVar is updated.
+	var lv slog.LevelVar
+	h = NewHandlerWithOpts(io.Discard, slog.HandlerOptions{Level: &lv})
+	h.SetLevel(slog.LevelWarn)
+	if got := lv.Level(); got != slog.LevelWarn {
+		t.Errorf("got LevelVar level %v, want WARN", got)
+	}
+
+	// A fixed level cannot be changed.
+	h = NewHandlerWithOpts(io.Discard, slog.HandlerOptions{Level: slog.LevelWarn})
+	if got := h.Level(); got != slog.LevelWarn {
+		t.Errorf("got level %v, want WARN", got)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("no panic from SetLevel with fixed level")
+			}
+		}()
+		h.SetLevel(slog.LevelDebug)
+	}()
+}
+
+func TestMaxPooledBufferSize(t *testing.T) {
+	h := NewHandlerWithOptions(io.Discard, Options{MaxPooledBufferSize: 4096})
+	ctx := context.Background()
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.String("big", strings.Repeat("x", 1<<20)))
+	if err := h.Handle(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := h.Handle(ctx, slog.NewRecord(time.Time{}, slog.LevelInfo, "small", 0)); err != nil {
+			t.Fatal(err)
+		}
+		buf := h.bufPool.get()
+		if n := cap(*buf); n > 4096 {
+			t.Fatalf("pooled buffer has capacity %d after huge record", n)
+		}
+		h.bufPool.put(buf)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("no panic for MaxPooledBufferSize less than InitialBufferSize")
+			}
+		}()
+		NewHandlerWithOptions(io.Discard, Options{InitialBufferSize: 8192, MaxPooledBufferSize: 4096})
+	}()
+}
+
 func TestNeedsQuoting(t *testing.T) {
 	for _, test := range []struct {
 		in   string
@@ -198,3 +547,1947 @@ func TestNeedsQuoting(t *testing.T) {
 		}
 	}
 }
+
+func TestNeedsQuotingExported(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"ab", false},
+		{"a b", true},
+		{"a=b", true},
+		{`"ab"`, true},
+		{`a\b`, false},
+		{"\a\b", true},
+		{"a\tb", true},
+		{"\xff", true},
+		{"µåπ", false},
+	} {
+		got := NeedsQuoting(test.in)
+		if got != test.want {
+			t.Errorf("%q: got %t, want %t", test.in, got, test.want)
+		}
+		// The exported function must agree with the handler.
+		var buf bytes.Buffer
+		h := NewHandler(&buf)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.String("x", test.in))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		quoted := strings.HasPrefix(buf.String(), `level=INFO msg=m x="`)
+		if quoted != got {
+			t.Errorf("%q: handler quoted %t, NeedsQuoting returned %t", test.in, quoted, got)
+		}
+	}
+}
+
+func TestDistinguishEmptyContainers(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		value   any
+		want    string
+		wantOff string
+	}{
+		{"nil slice", []int(nil), "null", "<nil>"},
+		{"empty slice", []int{}, "[]", "[]"},
+		{"nil map", map[string]int(nil), "null", "<nil>"},
+		{"empty map", map[string]int{}, "{}", "{}"},
+		{"nil pointer", (*int)(nil), "null", "<nil>"},
+		{"untyped nil", nil, "null", "<nil>"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, on := range []bool{true, false} {
+				var buf bytes.Buffer
+				h := NewHandlerWithOptions(&buf, Options{DistinguishEmptyContainers: on})
+				r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+				r.AddAttrs(slog.Any("x", test.value))
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				want := test.wantOff
+				if on {
+					want = test.want
+				}
+				want = "level=INFO msg=m x=" + want + "\n"
+				if got := buf.String(); got != want {
+					t.Errorf("option %v: got %q, want %q", on, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleEvery(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{Level: slog.LevelDebug},
+		Sample:         SampleEvery(map[slog.Level]int{slog.LevelDebug: 3}),
+	})
+	l := slog.New(h)
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		l.LogAttrs(ctx, slog.LevelDebug, "d", slog.Int("i", i))
+		l.LogAttrs(ctx, slog.LevelInfo, "i", slog.Int("i", i))
+	}
+	got := strings.Count(buf.String(), "level=DEBUG")
+	if got != 2 {
+		t.Errorf("got %d debug records, want 2", got)
+	}
+	got = strings.Count(buf.String(), "level=INFO")
+	if got != 6 {
+		t.Errorf("got %d info records, want 6", got)
+	}
+}
+
+func TestSampleEveryCopiesMap(t *testing.T) {
+	n := map[slog.Level]int{slog.LevelInfo: 2}
+	sample := SampleEvery(n)
+	n[slog.LevelInfo] = 1
+	n[slog.LevelWarn] = 2
+	got := 0
+	for i := 0; i < 4; i++ {
+		if sample(slog.LevelInfo) {
+			got++
+		}
+		if !sample(slog.LevelWarn) {
+			t.Errorf("WARN record %d was sampled out", i)
+		}
+	}
+	if got != 2 {
+		t.Errorf("got %d info records, want 2", got)
+	}
+}
+
+func TestSampleSkipsRecordConstruction(t *testing.T) {
+	h := NewHandlerWithOptions(io.Discard, Options{
+		Sample: func(slog.Level) bool { return false },
+	})
+	l := slog.New(h)
+	ctx := context.Background()
+	var v countingValuer
+	wantAllocs(t, 0, func() {
+		l.LogAttrs(ctx, slog.LevelInfo, "m", slog.Any("v", &v))
+	})
+	if v.n != 0 {
+		t.Errorf("LogValue called %d times, want 0", v.n)
+	}
+}
+
+type countingValuer struct {
+	n int
+}
+
+func (v *countingValuer) LogValue() slog.Value {
+	v.n++
+	return slog.IntValue(v.n)
+}
+
+func TestKeyAliases(t *testing.T) {
+	var buf bytes.Buffer
+	var h slog.Handler = NewHandlerWithOptions(&buf, Options{
+		KeyAliases: map[string]string{"usr": "user", "a b": "c"},
+	})
+	h = h.WithGroup("g")
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.String("usr", "bob"), slog.Int("a b", 1), slog.Int("other", 2))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m g.usr=bob g.user=bob "g.a b"=1 g.c=1 g.other=2` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestKeyAliasesBuiltins(t *testing.T) {
+	aliases := map[string]string{
+		slog.MessageKey: "message",
+		slog.LevelKey:   "severity",
+		"a":             "A",
+	}
+	want := `level=INFO severity=INFO msg=m message=m a=1 A=1` + "\n"
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{{
+		name: "plain",
+		opts: Options{KeyAliases: aliases},
+		want: want,
+	}, {
+		name: "replace-attr",
+		opts: Options{
+			HandlerOptions: slog.HandlerOptions{
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr { return a },
+			},
+			KeyAliases: aliases,
+		},
+		want: want,
+	}, {
+		name: "key-pattern",
+		opts: Options{
+			KeyAliases:  aliases,
+			KeyPattern:  regexp.MustCompile(`^[a-z]+$`),
+			KeyMismatch: KeyMismatchDrop,
+		},
+		want: `level=INFO severity=INFO msg=m message=m a=1` + "\n",
+	}, {
+		name: "key-pattern-flag",
+		opts: Options{
+			KeyAliases:  aliases,
+			KeyPattern:  regexp.MustCompile(`^[a-z]+$`),
+			KeyMismatch: KeyMismatchFlag,
+		},
+		want: `level=INFO severity=INFO msg=m message=m a=1 !A=1` + "\n",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Int("a", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func BenchmarkHandleStructAttrs(b *testing.B) {
+	type point struct {
+		X, Y int
+		Name string
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	for i := 0; i < 10; i++ {
+		r.AddAttrs(slog.Any("p", point{i, i * 2, "a<b>"}))
+	}
+	h := NewHandler(io.Discard)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Handle(ctx, r)
+	}
+}
+
+func BenchmarkHandleLargeRecord(b *testing.B) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.String("big", strings.Repeat("x", 20<<10)))
+	for _, size := range []int{0, 32 << 10} {
+		b.Run(fmt.Sprintf("InitialBufferSize=%d", size), func(b *testing.B) {
+			h := NewHandlerWithOptions(io.Discard, Options{InitialBufferSize: size})
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.Handle(ctx, r)
+			}
+		})
+	}
+}
+
+type goSyntaxT struct {
+	A int
+	b string
+}
+
+func TestGoSyntaxValues(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{GoSyntaxValues: true})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Any("t", goSyntaxT{A: 1}),
+		slog.Any("p", goSyntaxT{A: 2, b: "x y"}),
+		slog.Int("n", 3),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m t="slogtext.goSyntaxT{A:1, b:\"\"}" p="slogtext.goSyntaxT{A:2, b:\"x y\"}" n=3` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJoinGroups(t *testing.T) {
+	if got, want := JoinGroups(nil, "k", "."), "k"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := JoinGroups([]string{"a", "b"}, "k", "::"), "a::b::k"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Check that the keys reconstructed inside ReplaceAttr
+	// match the keys that the handler emits.
+	var joined []string
+	var buf bytes.Buffer
+	var h slog.Handler = NewHandlerWithOpts(&buf, slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 {
+				return slog.Attr{}
+			}
+			joined = append(joined, JoinGroups(groups, a.Key, "."))
+			return a
+		},
+	})
+	h = h.WithGroup("s").WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("t")
+	r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("a", 1), slog.Group("g", slog.Int("b", 2), slog.Group("h", slog.Int("c", 3))))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, f := range strings.Fields(buf.String()) {
+		k, _, _ := strings.Cut(f, "=")
+		keys = append(keys, k)
+	}
+	if got, want := strings.Join(joined, " "), strings.Join(keys, " "); got != want {
+		t.Errorf("joined keys %q do not match handler keys %q", got, want)
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	tm := time.Date(2000, 1, 2, 3, 4, 5, 123456789, tokyo)
+	epochSeconds := func(dst []byte, t time.Time) []byte {
+		return strconv.AppendInt(dst, t.Unix(), 10)
+	}
+	for _, test := range []struct {
+		name    string
+		opts    Options
+		time    time.Time
+		wantVal string
+	}{
+		{
+			name:    "default",
+			time:    tm,
+			wantVal: "2000-01-02T03:04:05.123+09:00",
+		},
+		{
+			name:    "nanoseconds",
+			opts:    Options{TimeLayout: time.RFC3339Nano},
+			time:    tm,
+			wantVal: "2000-01-02T03:04:05.123456789+09:00",
+		},
+		{
+			name:    "needs quoting",
+			opts:    Options{TimeLayout: "2006-01-02 15:04:05.000000"},
+			time:    tm,
+			wantVal: `"2000-01-02 03:04:05.123456"`,
+		},
+		{
+			name:    "callback",
+			opts:    Options{AppendTime: epochSeconds, TimeLayout: time.Kitchen},
+			time:    tm,
+			wantVal: "946749845",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, rep := range []bool{false, true} {
+				var buf bytes.Buffer
+				opts := test.opts
+				if rep {
+					opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+				}
+				h := NewHandlerWithOptions(&buf, opts)
+				r := slog.NewRecord(test.time, slog.LevelInfo, "m", 0)
+				r.AddAttrs(slog.Time("t", test.time))
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+				want := "time=" + test.wantVal + " level=INFO msg=m t=" + test.wantVal + "\n"
+				if got := buf.String(); got != want {
+					t.Errorf("replace %v: got %q, want %q", rep, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeFormatZeroTime(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{TimeLayout: time.RFC3339Nano})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDurationCompact(t *testing.T) {
+	for _, test := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{1500 * time.Microsecond, "1.5ms"},
+		{time.Second, "1s"},
+		{90 * time.Second, "1m30s"},
+		{2 * time.Minute, "2m"},
+		{time.Hour, "1h"},
+		{time.Hour + 30*time.Minute, "1h30m"},
+		{time.Hour + 5*time.Second, "1h0m5s"},
+		{-time.Hour, "-1h"},
+		{-1500 * time.Millisecond, "-1.5s"},
+		{10 * time.Minute, "10m"},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{DurationCompact: true})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Duration("d", test.d))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		want := "level=INFO msg=m d=" + test.want + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("%v: got %q, want %q", test.d, got, want)
+		}
+	}
+}
+
+func TestKVSeparator(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.String("a", "x=y"),
+		slog.String("b:c", "d"),
+		slog.String("e", "f g"),
+		slog.Any("j", map[string]int{"k": 1}),
+	}
+	for _, test := range []struct {
+		sep  string
+		want string
+	}{
+		{"", `time=2000-01-02T03:04:05.000Z level=INFO msg=m a="x=y" b:c=d e="f g" j={"k":1}`},
+		{"=", `time=2000-01-02T03:04:05.000Z level=INFO msg=m a="x=y" b:c=d e="f g" j={"k":1}`},
+		{":", `time:"2000-01-02T03:04:05.000Z" level:INFO msg:m a:x=y "b:c":d e:"f g" j:"{\"k\":1}"`},
+		{"=>", `time=>2000-01-02T03:04:05.000Z level=>INFO msg=>m a=>x=y b:c=>d e=>"f g" j=>{"k":1}`},
+	} {
+		t.Run(test.sep, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, Options{KVSeparator: test.sep})
+			r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+			r.AddAttrs(attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if got != test.want {
+				t.Errorf("\ngot  %s\nwant %s", got, test.want)
+			}
+			sep := test.sep
+			if sep == "" {
+				sep = "="
+			}
+			fields := parseLine(t, got, sep)
+			want := [][2]string{
+				{"time", "2000-01-02T03:04:05.000Z"},
+				{"level", "INFO"},
+				{"msg", "m"},
+				{"a", "x=y"},
+				{"b:c", "d"},
+				{"e", "f g"},
+				{"j", `{"k":1}`},
+			}
+			if fmt.Sprint(fields) != fmt.Sprint(want) {
+				t.Errorf("round trip: got %q, want %q", fields, want)
+			}
+		})
+	}
+}
+
+func TestKVSeparatorInvalid(t *testing.T) {
+	for _, sep := range []string{" ", `"`, ": ", "\t"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic for separator %q", sep)
+				}
+			}()
+			NewHandlerWithOptions(io.Discard, Options{KVSeparator: sep})
+		}()
+	}
+}
+
+// parseLine parses a line of space-separated key/value pairs
+// with the given separator, unquoting keys and values as needed.
+func parseLine(t *testing.T, line, sep string) [][2]string {
+	var fields [][2]string
+	token := func() string {
+		if strings.HasPrefix(line, `"`) {
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				t.Fatalf("bad quoted string in %q: %v", line, err)
+			}
+			line = line[len(q):]
+			s, _ := strconv.Unquote(q)
+			return s
+		}
+		i := strings.Index(line, sep)
+		if j := strings.IndexByte(line, ' '); i < 0 || (j >= 0 && j < i) {
+			i = j
+		}
+		if i < 0 {
+			i = len(line)
+		}
+		s := line[:i]
+		line = line[i:]
+		return s
+	}
+	for line != "" {
+		k := token()
+		if !strings.HasPrefix(line, sep) {
+			t.Fatalf("missing separator before %q", line)
+		}
+		line = line[len(sep):]
+		v := token()
+		fields = append(fields, [2]string{k, v})
+		line = strings.TrimPrefix(line, " ")
+	}
+	return fields
+}
+
+func TestFieldSeparator(t *testing.T) {
+	defaultAttrs := []slog.Attr{slog.String("a", "x y"), slog.String("b", "c|d")}
+	for _, test := range []struct {
+		sep   string
+		attrs []slog.Attr
+		want  string
+	}{
+		{"", defaultAttrs, `level=INFO msg=m p=1 g.a="x y" g.b=c|d`},
+		{"\t", defaultAttrs, "level=INFO\tmsg=m\tp=1\tg.a=\"x y\"\tg.b=c|d"},
+		{" | ", defaultAttrs, `level=INFO | msg=m | p=1 | g.a="x y" | g.b=c|d`},
+		{"|", defaultAttrs, `level=INFO|msg=m|p=1|g.a="x y"|g.b="c|d"`},
+		// Values written without a quoting check of their own.
+		{",", []slog.Attr{slog.Any("s", []int{1, 2}), slog.Int("b", 1)}, `level=INFO,msg=m,p=1,g.s="[1,2]",g.b=1`},
+		{",", []slog.Attr{slog.Any("r", json.RawMessage(`{"a":[1,2]}`)), slog.Int("b", 1)}, `level=INFO,msg=m,p=1,g.r="{\"a\":[1,2]}",g.b=1`},
+		{" | ", []slog.Attr{slog.Any("s", []string{"a | b"}), slog.Int("b", 1)}, `level=INFO | msg=m | p=1 | g.s="[\"a | b\"]" | g.b=1`},
+		{".", []slog.Attr{slog.Duration("d", 1500*time.Millisecond), slog.Float64("f", 1.5)}, `level=INFO.msg=m.p=1."g.d"="1.5s"."g.f"="1.5"`},
+	} {
+		t.Run(test.sep, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, Options{FieldSeparator: test.sep})
+			h = h.WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("g")
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFieldSeparatorAlloc(t *testing.T) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	for i := 0; i < 10; i++ {
+		r.AddAttrs(slog.Int("x = y", i))
+	}
+	var h slog.Handler = NewHandlerWithOptions(io.Discard, Options{FieldSeparator: "\t"})
+	h = h.WithAttrs([]slog.Attr{slog.Int("p", 1)})
+	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
+}
+
+func TestLineTerminator(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, "level=INFO msg=m\n"},
+		{"CRLF", Options{LineTerminator: "\r\n"}, "level=INFO msg=m\r\n"},
+		{"NUL", Options{LineTerminator: "\x00"}, "level=INFO msg=m\x00"},
+		{"none", Options{NoNewline: true}, "level=INFO msg=m"},
+		{"prototext", Options{Layout: LayoutProtoText, LineTerminator: "\x00"}, `level: "INFO" msg: "m"` + "\x00"},
+		{"xml", Options{Layout: LayoutXML, NoNewline: true}, `<record level="INFO"><msg>m</msg></record>`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &countingWriter{w: &buf}
+			h := NewHandlerWithOptions(w, test.opts)
+			for i := 0; i < 2; i++ {
+				if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := buf.String(), test.want+test.want; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if w.n != 2 {
+				t.Errorf("got %d writes, want 2", w.n)
+			}
+		})
+	}
+}
+
+func TestKeyPattern(t *testing.T) {
+	for _, test := range []struct {
+		action KeyMismatchAction
+		want   string
+	}{
+		{KeyMismatchDrop, "level=INFO msg=m good_key=1 g.ok=3"},
+		{KeyMismatchFlag, "level=INFO msg=m good_key=1 !CamelKey=2 g.ok=3 g.!Bad=4"},
+		{KeyMismatchRename, "level=INFO msg=m good_key=1 !BADKEY=2 g.ok=3 g.!BADKEY=4"},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{
+			KeyPattern:  regexp.MustCompile(`^[a-z_]+$`),
+			KeyMismatch: test.action,
+		})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("good_key", 1), slog.Int("CamelKey", 2), slog.Group("g", slog.Int("ok", 3), slog.Int("Bad", 4)))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), test.want+"\n"; got != want {
+			t.Errorf("action %d: got %q, want %q", test.action, got, want)
+		}
+	}
+}
+
+func TestKeyUnits(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		KeyUnits: map[string]string{"latency": "ms", "size": " bytes"},
+	})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Int("latency", 12),
+		slog.Group("db", slog.Float64("latency", 1.5), slog.String("latency", "slow")),
+		slog.Uint64("size", 10),
+		slog.Int("other", 3),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m latency=12ms db.latency=1.5ms db.latency=slow size="10 bytes" other=3` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	var buf bytes.Buffer
+	var order []string
+	var h slog.Handler = NewHandlerWithOptions(&buf, Options{
+		HandlerOptions: slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) > 0 || a.Key != slog.TimeKey && a.Key != slog.LevelKey && a.Key != slog.MessageKey {
+					order = append(order, a.Key)
+				}
+				return a
+			},
+		},
+		SortKeys: true,
+	})
+	h = h.WithAttrs([]slog.Attr{slog.Int("z", 1), slog.Int("b", 2)}).WithGroup("s")
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Int("y", 3),
+		slog.Group("g", slog.Int("q", 4), slog.Group("h", slog.Int("c", 5)), slog.Int("a", 6)),
+		slog.String("x", "two words"),
+		slog.Int("y", 7),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m b=2 s.g.a=6 s.g.h.c=5 s.g.q=4 s.x="two words" s.y=3 s.y=7 z=1` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\ngot  %s\nwant %s", got, want)
+	}
+	if got, want := strings.Join(order, " "), "z b y q c a x y"; got != want {
+		t.Errorf("ReplaceAttr order: got %q, want %q", got, want)
+	}
+}
+
+func TestSortKeysFieldSeparator(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{SortKeys: true, FieldSeparator: " | "})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("c", 1), slog.Int("a", 2), slog.Int("b", 3))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO | msg=m | a=2 | b=3 | c=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQuoteFunc(t *testing.T) {
+	allDigits := func(s string) bool {
+		if s == "" {
+			return false
+		}
+		for _, r := range s {
+			if r < '0' || r > '9' {
+				return needsQuoting(s) && !strings.Contains(s, "/")
+			}
+		}
+		return true
+	}
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{QuoteFunc: allDigits})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.String("id", "123"), slog.String("path", "a/b"), slog.String("123", "x y"), slog.Int("n", 4))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m id="123" path=a/b "123"="x y" n=4` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMaxKeyBytes(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		with  func(slog.Handler) slog.Handler
+		attrs []slog.Attr
+		want  string
+	}{
+		{
+			name:  "short key",
+			attrs: []slog.Attr{slog.Int("abc", 1)},
+			want:  "level=INFO msg=m abc=1",
+		},
+		{
+			name:  "at limit",
+			attrs: []slog.Attr{slog.Int("abcdef", 1)},
+			want:  "level=INFO msg=m abcdef=1",
+		},
+		{
+			name:  "long key",
+			attrs: []slog.Attr{slog.Int("abcdefghij", 1)},
+			want:  "level=INFO msg=m abcdef…=1",
+		},
+		{
+			name:  "multibyte before boundary",
+			attrs: []slog.Attr{slog.Int("abcdé€x", 1)},
+			want:  "level=INFO msg=m abcdé…=1",
+		},
+		{
+			name:  "multibyte across boundary",
+			attrs: []slog.Attr{slog.Int("abcdeé", 1)},
+			want:  "level=INFO msg=m abcde…=1",
+		},
+		{
+			name:  "group prefix counts",
+			with:  func(h slog.Handler) slog.Handler { return h.WithGroup("grp") },
+			attrs: []slog.Attr{slog.Int("abcd", 1)},
+			want:  "level=INFO msg=m grp.ab…=1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, Options{MaxKeyBytes: 6})
+			if test.with != nil {
+				h = test.with(h)
+			}
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestKeyValueQuoteFuncs(t *testing.T) {
+	always := func(string) bool { return true }
+	never := func(string) bool { return false }
+	var gotKeys []string
+	recordKey := func(s string) bool {
+		gotKeys = append(gotKeys, s)
+		return needsQuoting(s)
+	}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "quote values",
+			opts: Options{ValueQuoteFunc: always},
+			want: `time="2000-01-02T03:04:05.000Z" level="INFO" msg="m" g.a="x" g.b=2`,
+		},
+		{
+			name: "quote keys",
+			opts: Options{KeyQuoteFunc: always, ValueQuoteFunc: never},
+			want: `"time"=2000-01-02T03:04:05.000Z "level"=INFO "msg"=m "g.a"=x "g.b"=2`,
+		},
+		{
+			name: "override QuoteFunc",
+			opts: Options{QuoteFunc: always, KeyQuoteFunc: recordKey},
+			want: `time="2000-01-02T03:04:05.000Z" level="INFO" msg="m" g.a="x" g.b=2`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts).WithGroup("g")
+			r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.String("a", "x"), slog.Int("b", 2))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+	if got, want := strings.Join(gotKeys, " "), "time level msg g.a g.b"; got != want {
+		t.Errorf("KeyQuoteFunc called with %q, want %q", got, want)
+	}
+}
+
+func TestBytesEncoding(t *testing.T) {
+	type myBytes []byte
+	for _, test := range []struct {
+		enc  BytesEncoding
+		v    any
+		want string
+	}{
+		{BytesQuoted, []byte{1, 2, 3, 4}, `bs="\x01\x02\x03\x04"`},
+		{BytesBase64, []byte{1, 2, 3, 4}, `bs="AQIDBA=="`},
+		{BytesBase64, []byte{1, 2, 3}, `bs=AQID`},
+		{BytesBase64, myBytes{0xfb, 0xff}, `bs="+/8="`},
+		{BytesHex, []byte{1, 2, 0xab, 0xff}, `bs=0102abff`},
+		{BytesHex, []byte{}, `bs=`},
+		{BytesQuoted, json.RawMessage("1234"), `bs=1234`},
+		{BytesBase64, json.RawMessage("1234"), `bs=1234`},
+		{BytesHex, json.RawMessage(`{"a"`), `bs="{\"a\""`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{BytesEncoding: test.enc})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Any("bs", test.v))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("encoding %d, %v: got %q, want %q", test.enc, test.v, got, want)
+		}
+	}
+}
+
+func TestJSONRawMessage(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want string
+	}{
+		{`{"a":1}`, `bs={"a":1}`},
+		{`[1,"x",null]`, `bs=[1,"x",null]`},
+		{`"s"`, `bs="s"`},
+		{`{"a": 1}`, `bs="{\"a\": 1}"`},
+		{`{"a":"x=y"}`, `bs="{\"a\":\"x=y\"}"`},
+		{"[1,\n2]", `bs="[1,\n2]"`},
+		{`{"a":1`, `bs="{\"a\":1"`},
+		{`[1,]`, `bs="[1,]"`},
+		{``, `bs=""`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandler(&buf)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Any("bs", json.RawMessage(test.raw)))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("%q: got %q, want %q", test.raw, got, want)
+		}
+	}
+}
+
+func TestNonFiniteFloat(t *testing.T) {
+	type myFloat float64
+	type withFloat struct{ F float64 }
+	attrs := []slog.Attr{
+		slog.Float64("nan", math.NaN()),
+		slog.Float64("pinf", math.Inf(1)),
+		slog.Any("ninf", math.Inf(-1)),
+		slog.Any("f32", float32(math.Inf(1))),
+		slog.Any("named", myFloat(math.NaN())),
+		slog.Any("struct", withFloat{math.Inf(-1)}),
+		slog.Float64("finite", 1.5),
+	}
+	for _, test := range []struct {
+		mode NonFiniteFloat
+		want string
+	}{
+		{NonFiniteQuoted, `nan="NaN" pinf="+Inf" ninf="-Inf" f32="+Inf" named="!ERROR:cannot encode NaN as JSON" struct="!ERROR:cannot encode -Inf as JSON" finite=1.5`},
+		{NonFiniteNull, `nan=null pinf=null ninf=null f32=null named="!ERROR:cannot encode NaN as JSON" struct="!ERROR:cannot encode -Inf as JSON" finite=1.5`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{NonFiniteFloat: test.mode})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("mode %d:\ngot  %s\nwant %s", test.mode, got, want)
+		}
+	}
+}
+
+func TestLevelLabels(t *testing.T) {
+	levels := []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelInfo + 2, slog.LevelWarn, slog.LevelError}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{"DEBUG", "INFO", "INFO+2", "WARN", "ERROR"},
+		},
+		{
+			name: "labels",
+			opts: Options{LevelLabels: map[slog.Level]string{slog.LevelWarn: "warning", slog.LevelError: "ERR"}},
+			want: []string{"DEBUG", "INFO", "INFO+2", "warning", "ERR"},
+		},
+		{
+			name: "lowercase",
+			opts: Options{LowercaseLevels: true},
+			want: []string{"debug", "info", "INFO+2", "warn", "error"},
+		},
+		{
+			name: "labels take precedence",
+			opts: Options{
+				LowercaseLevels: true,
+				LevelLabels:     map[slog.Level]string{slog.LevelWarn: "warning", slog.LevelInfo + 2: "notice"},
+			},
+			want: []string{"debug", "info", "notice", "warning", "error"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			for i, l := range levels {
+				buf.Reset()
+				if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, l, "m", 0)); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := buf.String(), "level="+test.want[i]+" msg=m\n"; got != want {
+					t.Errorf("level %v: got %q, want %q", l, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBareBoolFlags(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.Bool("t", true),
+		slog.Int("a", 1),
+		slog.Bool("f", false),
+		slog.Group("g", slog.Bool("t", true), slog.Bool("f", false)),
+		slog.Bool("last", true),
+	}
+	for _, test := range []struct {
+		name string
+		opts Options
+		with func(slog.Handler) slog.Handler
+		want string
+	}{
+		{
+			name: "default",
+			want: `msg=m t=true a=1 f=false g.t=true g.f=false last=true`,
+		},
+		{
+			name: "bare",
+			opts: Options{BareBoolFlags: true},
+			want: `msg=m t a=1 g.t last`,
+		},
+		{
+			name: "negated",
+			opts: Options{BareBoolFlags: true, NegateFalseFlags: true},
+			want: `msg=m t a=1 !f g.t !g.f last`,
+		},
+		{
+			name: "preformatted",
+			opts: Options{BareBoolFlags: true, NegateFalseFlags: true},
+			with: func(h slog.Handler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.Bool("p", true), slog.Bool("q", false)})
+			},
+			want: `msg=m p !q t a=1 !f g.t !g.f last`,
+		},
+		{
+			name: "sorted and abbreviated",
+			opts: Options{BareBoolFlags: true, NegateFalseFlags: true, SortKeys: true, AbbreviateGroupPrefixes: true},
+			want: `msg=m @1=g a=1 !f !@1.f @1.t last t`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := test.opts
+			opts.ReplaceAttr = removeKeys(slog.LevelKey)
+			var h slog.Handler = NewHandlerWithOptions(&buf, opts)
+			if test.with != nil {
+				h = test.with(h)
+			}
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// orderedJSON is a json.Marshaler that writes its keys
+// in insertion order.
+type orderedJSON [][2]any
+
+func (o orderedJSON) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, kv := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(kv[0])
+		v, err := json.Marshal(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s:%s", k, v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	a := orderedJSON{{"b", 1}, {"a", orderedJSON{{"y", "<&>"}, {"x", uint64(12345678901234567890)}}}}
+	b := orderedJSON{{"a", orderedJSON{{"x", uint64(12345678901234567890)}, {"y", "<&>"}}}, {"b", 1}}
+	format := func(opts Options, v any) string {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, opts)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Any("v", v))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	if format(Options{}, a) == format(Options{}, b) {
+		t.Fatalf("values unexpectedly formatted the same without CanonicalJSON")
+	}
+	opts := Options{CanonicalJSON: true}
+	want := `level=INFO msg=m v={"a":{"x":12345678901234567890,"y":"<&>"},"b":1}` + "\n"
+	for _, v := range []any{a, b} {
+		if got := format(opts, v); got != want {
+			t.Errorf("got  %q\nwant %q", got, want)
+		}
+	}
+	if got, want := format(opts, map[int]any{2: []int{1}, 10: nil}), `level=INFO msg=m v={"10":null,"2":[1]}`+"\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic for CanonicalJSON with JSONIndent")
+		}
+	}()
+	NewHandlerWithOptions(io.Discard, Options{CanonicalJSON: true, JSONIndent: "  "})
+}
+
+func TestMarshalJSON(t *testing.T) {
+	errMarshal := errors.New("cannot marshal")
+	marshal := func(v any) ([]byte, error) {
+		switch v := v.(type) {
+		case []int:
+			return []byte("SENTINEL"), nil
+		case map[string]string:
+			return []byte(`{"k": "` + v["k"] + `"}`), nil
+		}
+		return nil, errMarshal
+	}
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{MarshalJSON: marshal})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Any("a", []int{1}),
+		slog.Any("b", map[string]string{"k": "v"}),
+		slog.Any("c", struct{}{}),
+		slog.Int("d", 1),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m a=SENTINEL b="{\"k\": \"v\"}" c="!ERROR:cannot marshal" d=1` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestJSONNoHTMLEscape(t *testing.T) {
+	type link struct {
+		URL  string
+		HTML string
+	}
+	var buf bytes.Buffer
+	h := NewHandler(&buf)
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Any("link", link{"https://example.com/search?q=a&n=10", "<b>x</b>"}))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m link={"URL":"https://example.com/search?q=a&n=10","HTML":"<b>x</b>"}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestAlwaysQuote(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.Int("i", 1),
+		slog.Bool("b", true),
+		slog.Float64("f", 1.5),
+		slog.Duration("d", time.Second),
+		slog.String("s", "x"),
+		slog.String("q", `"already"`),
+		slog.String("e", ""),
+		slog.Any("j", []int{1, 2}),
+		slog.Group("g", slog.Int("n", 2)),
+	}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{{
+		name: "values",
+		opts: Options{AlwaysQuoteValues: true},
+		want: `time="2000-01-02T03:04:05.000Z" level="INFO" msg="m" i="1" b="true" f="1.5" d="1s" s="x" q="\"already\"" e="" j="[1,2]" g.n="2"`,
+	}, {
+		name: "keys",
+		opts: Options{AlwaysQuoteKeys: true},
+		want: `"time"=2000-01-02T03:04:05.000Z "level"=INFO "msg"=m "i"=1 "b"=true "f"=1.5 "d"=1s "s"=x "q"="\"already\"" "e"= "j"=[1,2] "g.n"=2`,
+	}, {
+		name: "both",
+		opts: Options{AlwaysQuoteValues: true, AlwaysQuoteKeys: true, SortKeys: true},
+		want: `"time"="2000-01-02T03:04:05.000Z" "level"="INFO" "msg"="m" "b"="true" "d"="1s" "e"="" "f"="1.5" "g.n"="2" "i"="1" "j"="[1,2]" "q"="\"already\"" "s"="x"`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts)
+			r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+			r.AddAttrs(attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestValueFormatters(t *testing.T) {
+	formatters := map[string]func(slog.Value, *[]byte){
+		"req.latency": func(v slog.Value, buf *[]byte) {
+			*buf = strconv.AppendFloat(*buf, float64(v.Duration())/float64(time.Millisecond), 'f', 1, 64)
+			*buf = append(*buf, "ms"...)
+		},
+		"note": func(v slog.Value, buf *[]byte) {
+			*buf = append(*buf, "<"+v.String()+">"...)
+		},
+	}
+	var buf bytes.Buffer
+	var h slog.Handler = NewHandlerWithOptions(&buf, Options{ValueFormatters: formatters})
+	h = h.WithAttrs([]slog.Attr{slog.String("note", "a b")})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Group("req", slog.Duration("latency", 12345*time.Microsecond)),
+		slog.Duration("latency", time.Second),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m note="<a b>" req.latency=12.3ms latency=1s` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestMaxValueLen(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		opts  Options
+		value slog.Value
+		want  string
+	}{
+		{"short", Options{}, slog.StringValue("abcdef"), `v=abcdef`},
+		{"at limit", Options{}, slog.StringValue("abcdefghij"), `v=abcdefghij`},
+		{"ascii", Options{}, slog.StringValue("abcdefghijk"), `v=abcdefghij…`},
+		{"multibyte", Options{}, slog.StringValue("aééééé"), `v=aéééé…`},
+		{"quoted", Options{}, slog.StringValue("a b c d e f g"), `v="a b c d e …"`},
+		{"escapes", Options{}, slog.StringValue("\n\n\n\n\n\n\n\n\n\n\n"), `v="\n\n\n\n\n\n\n\n\n\n…"`},
+		{"quoted at limit", Options{}, slog.StringValue("a\tb\tc\td\te"), `v="a\tb\tc\td\te"`},
+		{"number", Options{}, slog.Int64Value(123456789012), `v=1234567890…`},
+		{"json", Options{}, slog.AnyValue([]int{1, 2, 3, 4, 5}), `v=[1,2,3,4,5…`},
+		{"marker", Options{TruncationMarker: "[cut]"}, slog.StringValue("abcdefghijk"), `v=abcdefghij[cut]`},
+		{"quoted marker", Options{TruncationMarker: " (more)"}, slog.StringValue("abcdefghijk"), `v="abcdefghij (more)"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := test.opts
+			opts.MaxValueLen = 10
+			h := NewHandlerWithOptions(&buf, opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Attr{Key: "v", Value: test.value})
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMaxRecordLen(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.String("a", "one two"),
+		slog.Any("j", map[string]string{"k": "x y"}),
+		slog.String("s", strings.Repeat("x", 30)),
+	}
+	long := `level=INFO msg=m a="one two" j={"k":"x y"} s=` + strings.Repeat("x", 30)
+	for _, test := range []struct {
+		name string
+		max  int
+		msg  string
+		want string
+	}{
+		{"short", 100, "m", long},
+		{"exact", len(long), "m", long},
+		{"drop last", len(long) - 1, "m", `level=INFO msg=m a="one two" j={"k":"x y"} … (truncated)`},
+		// The space inside the JSON value is not a boundary.
+		{"drop two", 57, "m", `level=INFO msg=m a="one two" … (truncated)`},
+		{"drop all", 40, "m", `level=INFO msg=m … (truncated)`},
+		{"long message", 20, "a long message", `level=INFO msg="a long message" … (truncated)`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, Options{MaxRecordLen: test.max})
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, test.msg, 0)
+			r.AddAttrs(attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMaxRecordLenAlignKeys(t *testing.T) {
+	for _, test := range []struct {
+		max  int
+		want string
+	}{
+		{45, "level= INFO msg=   m a=     1 … (truncated)"},
+		// The padding after a= is not a boundary between attributes.
+		{44, "level= INFO msg=   m … (truncated)"},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{AlignKeys: 6, MaxRecordLen: test.max})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("a", 1), slog.String("b", strings.Repeat("x", 20)))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), test.want+"\n"; got != want {
+			t.Errorf("max %d: got %q, want %q", test.max, got, want)
+		}
+	}
+}
+
+func TestOmitNil(t *testing.T) {
+	type T struct{ A int }
+	var nilErr *os.PathError
+	attrs := []slog.Attr{
+		slog.Any("untyped", nil),
+		slog.Any("ptr", (*T)(nil)),
+		slog.Any("err", error(nilErr)),
+		slog.Any("slice", []int(nil)),
+		slog.Any("map", map[string]int(nil)),
+		slog.Any("val", &T{1}),
+		slog.Int("n", 0),
+		slog.String("s", ""),
+		slog.Group("g", slog.Any("x", nil)),
+	}
+	for _, test := range []struct {
+		omit bool
+		want string
+	}{
+		{false, `untyped=<nil> ptr=<nil> err=<nil> slice=<nil> map=<nil> val={"A":1} n=0 s= g.x=<nil>`},
+		{true, `slice=<nil> map=<nil> val={"A":1} n=0 s=`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{OmitNil: test.omit})
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+		r.AddAttrs(attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "level=INFO msg=m "+test.want+"\n"; got != want {
+			t.Errorf("OmitNil %v:\ngot  %s\nwant %s", test.omit, got, want)
+		}
+	}
+}
+
+func TestBadKeyStringErrorPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		BadKeyString: "BADKEY",
+		ErrorPrefix:  "ERR ",
+	})
+	l := slog.New(h)
+	// The argument 1 has no key.
+	l.Info("m", "a", func() {}, 1)
+	want := `level=INFO msg=m a="ERR json: unsupported type: func()" BADKEY=1` + "\n"
+	if got := removeTimes(buf.String()); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	buf.Reset()
+	h = NewHandlerWithOptions(&buf, Options{
+		BadKeyString: "BADKEY",
+		KeyPattern:   regexp.MustCompile(`^[a-z]+$`),
+		KeyMismatch:  KeyMismatchRename,
+	})
+	slog.New(h).Info("m", "B", 2)
+	want = `level=INFO msg=m BADKEY=2` + "\n"
+	if got := removeTimes(buf.String()); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestErrorPrefixLayouts(t *testing.T) {
+	for _, opts := range []Options{
+		{Layout: LayoutProtoText},
+		{Layout: LayoutXML},
+		{JSONSidecar: true},
+	} {
+		var buf bytes.Buffer
+		opts.ErrorPrefix = "ERR "
+		slog.New(NewHandlerWithOptions(&buf, opts)).Info("m", "a", func() {}, "t", text{})
+		got := buf.String()
+		if strings.Contains(got, "!ERROR") || !strings.Contains(got, "ERR json: unsupported type") || !strings.Contains(got, "ERR text: empty string") {
+			t.Errorf("%+v: got %q", opts, got)
+		}
+	}
+}
+
+func TestHandleN(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(&buf)
+	r := slog.NewRecord(testTime, slog.LevelInfo, "hello", 0)
+	r.AddAttrs(slog.String("a", "x y"), slog.Int("b", 1))
+	n, err := h.HandleN(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != buf.Len() {
+		t.Errorf("got %d bytes, want %d (%q)", n, buf.Len(), buf.String())
+	}
+	if want := len("time=2000-01-02T03:04:05.000Z level=INFO msg=hello a=\"x y\" b=1\n"); n != want {
+		t.Errorf("got %d bytes, want %d", n, want)
+	}
+}
+
+// limitWriter is a writer that fails once more than n bytes
+// have been written to it.
+type limitWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errLimit = errors.New("write limit exceeded")
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.n {
+		return 0, errLimit
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestOnError(t *testing.T) {
+	w := &limitWriter{n: 60}
+	var errs []error
+	var h *Handler
+	h = NewHandlerWithOptions(w, Options{
+		OnError: func(err error) {
+			errs = append(errs, err)
+			// The lock must not be held, so that it's OK to
+			// use the handler here.
+			h.Flush()
+		},
+	})
+	for i := 0; i < 4; i++ {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "message", 0)
+		r.AddAttrs(slog.Int("i", i))
+		err := h.Handle(context.Background(), r)
+		if (i >= 2) != (err != nil) {
+			t.Errorf("record %d: unexpected error %v", i, err)
+		}
+	}
+	if len(errs) != 2 || errs[0] != errLimit || errs[1] != errLimit {
+		t.Errorf("got errors %v, want two of %v", errs, errLimit)
+	}
+	if got, want := w.String(), "level=INFO msg=message i=0\nlevel=INFO msg=message i=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// readerFromWriter records the calls to its Write and ReadFrom methods.
+type readerFromWriter struct {
+	buf       bytes.Buffer
+	writes    int
+	readFroms int
+}
+
+func (w *readerFromWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func (w *readerFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFroms++
+	// Read in small chunks, as a framed transport might.
+	var n int64
+	chunk := make([]byte, 3)
+	for {
+		m, err := r.Read(chunk)
+		w.buf.Write(chunk[:m])
+		n += int64(m)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+func TestUseReaderFrom(t *testing.T) {
+	for _, use := range []bool{false, true} {
+		w := &readerFromWriter{}
+		h := NewHandlerWithOptions(w, Options{UseReaderFrom: use})
+		r := slog.NewRecord(testTime, slog.LevelInfo, "hello", 0)
+		r.AddAttrs(slog.String("a", "x y"))
+		n, err := h.HandleN(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "time=2000-01-02T03:04:05.000Z level=INFO msg=hello a=\"x y\"\n"
+		if got := w.buf.String(); got != want {
+			t.Errorf("UseReaderFrom=%v: got %q, want %q", use, got, want)
+		}
+		if n != len(want) {
+			t.Errorf("UseReaderFrom=%v: got %d bytes, want %d", use, n, len(want))
+		}
+		wantWrites, wantReadFroms := 1, 0
+		if use {
+			wantWrites, wantReadFroms = 0, 1
+		}
+		if w.writes != wantWrites || w.readFroms != wantReadFroms {
+			t.Errorf("UseReaderFrom=%v: got %d writes and %d reads, want %d and %d", use, w.writes, w.readFroms, wantWrites, wantReadFroms)
+		}
+	}
+}
+
+func TestLinePrefix(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{{
+		name: "none",
+		want: `time=2000-01-02T03:04:05.000Z level=INFO msg=hello g.a=1 g.b="x y z w v u t s"`,
+	}, {
+		name: "prefix",
+		opts: Options{LinePrefix: "[svc-a] "},
+		want: `[svc-a] time=2000-01-02T03:04:05.000Z level=INFO msg=hello g.a=1 g.b="x y z w v u t s"`,
+	}, {
+		name: "unquoted",
+		opts: Options{LinePrefix: `"a b"=`},
+		want: `"a b"=time=2000-01-02T03:04:05.000Z level=INFO msg=hello g.a=1 g.b="x y z w v u t s"`,
+	}, {
+		name: "max-record-len",
+		opts: Options{LinePrefix: "[svc-a] ", MaxRecordLen: 72},
+		want: `[svc-a] time=2000-01-02T03:04:05.000Z level=INFO msg=hello g.a=1 … (truncated)`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewHandlerWithOptions(&buf, test.opts).WithGroup("g").WithAttrs([]slog.Attr{slog.Int("a", 1)})
+			r := slog.NewRecord(testTime, slog.LevelInfo, "hello", 0)
+			r.AddAttrs(slog.String("b", "x y z w v u t s"))
+			n, err := h.(*Handler).HandleN(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != test.want {
+				t.Errorf("\ngot  %s\nwant %s", got, test.want)
+			}
+			if n != buf.Len() {
+				t.Errorf("got %d bytes, want %d", n, buf.Len())
+			}
+		})
+	}
+}
+
+func TestTimeAttrsMatchBuiltinTime(t *testing.T) {
+	tm := time.Date(2000, 1, 2, 3, 4, 5, 678e6, time.FixedZone("", 9*3600))
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, "2000-01-02T03:04:05.678+09:00"},
+		{"layout", Options{TimeLayout: time.RFC1123Z}, `"Sun, 02 Jan 2000 03:04:05 +0900"`},
+		{"append", Options{AppendTime: func(dst []byte, t time.Time) []byte {
+			return t.AppendFormat(dst, "15:04:05.000000")
+		}}, "03:04:05.678000"},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, test.opts)
+		r := slog.NewRecord(tm, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Time("event_at", tm))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		want := "time=" + test.want + " level=INFO msg=m event_at=" + test.want + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("%s: got %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestTimeLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("cannot load location: %v", err)
+	}
+	tm := time.Date(2000, 1, 2, 12, 4, 5, 678e6, time.FixedZone("", 9*3600))
+	for _, test := range []struct {
+		loc  *time.Location
+		want string
+	}{
+		{nil, "2000-01-02T12:04:05.678+09:00"},
+		{time.UTC, "2000-01-02T03:04:05.678Z"},
+		{newYork, "2000-01-01T22:04:05.678-05:00"},
+	} {
+		for _, replace := range []bool{false, true} {
+			var buf bytes.Buffer
+			var seen time.Time
+			opts := Options{TimeLocation: test.loc}
+			if replace {
+				opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey && len(groups) == 0 {
+						seen = a.Value.Time()
+					}
+					return a
+				}
+			}
+			h := NewHandlerWithOptions(&buf, opts)
+			r := slog.NewRecord(tm, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Time("t", tm))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			want := "time=" + test.want + " level=INFO msg=m t=" + test.want + "\n"
+			if got := buf.String(); got != want {
+				t.Errorf("%v, replace=%t: got %q, want %q", test.loc, replace, got, want)
+			}
+			if replace {
+				if test.loc != nil && seen.Location() != test.loc {
+					t.Errorf("%v: ReplaceAttr saw time in %v", test.loc, seen.Location())
+				}
+			}
+		}
+	}
+}
+
+func TestTimeFormatUnix(t *testing.T) {
+	for _, test := range []struct {
+		format TimeFormat
+		tm     time.Time
+		want   string
+	}{
+		{TimeUnixSeconds, testTime, "946782245"},
+		{TimeUnixMillis, testTime.Add(678 * time.Millisecond), "946782245678"},
+		{TimeUnixNanos, testTime.Add(678 * time.Nanosecond), "946782245000000678"},
+		{TimeUnixSeconds, time.Date(1969, 12, 31, 23, 59, 58, 0, time.UTC), "-2"},
+		{TimeUnixMillis, time.Date(1969, 12, 31, 23, 59, 59, 500e6, time.UTC), "-500"},
+		{TimeUnixNanos, time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC), "-1"},
+		{TimeUnixSeconds, time.Unix(1, 0).In(time.FixedZone("", 3600)), "1"},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, Options{TimeFormat: test.format})
+		r := slog.NewRecord(test.tm, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Time("t", test.tm))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		want := "time=" + test.want + " level=INFO msg=m t=" + test.want + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("format %d, %v: got %q, want %q", test.format, test.tm, got, want)
+		}
+	}
+
+	// The zero time is still omitted.
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{TimeFormat: TimeUnixNanos})
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "level=INFO msg=m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "m", 0)
+	h = NewHandlerWithOptions(io.Discard, Options{TimeFormat: TimeUnixMillis})
+	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
+}
+
+func TestAlignKeys(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{AlignKeys: 6}).WithGroup("g")
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(slog.Int("a", 1), slog.String("é", "x"), slog.String("long", "y"), slog.String("a b", "z"))
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level= INFO msg=   m g.a=   1 g.é=   x g.long=y "g.a b"=z` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, opts := range []Options{{}, {SortKeys: true}, {Layout: LayoutXML}, {StrictGroups: true}} {
+		var buf1, buf2 bytes.Buffer
+		h := NewHandlerWithOptions(&buf1, opts).
+			WithAttrs([]slog.Attr{slog.Int("a", 1)}).
+			WithGroup("g.h"). // rejected by StrictGroups
+			WithAttrs([]slog.Attr{slog.Int("b", 2)}).(*Handler)
+		h2 := h.WithAttrs([]slog.Attr{slog.Int("c", 3)}).(*Handler)
+		h.Reset()
+		fresh := NewHandlerWithOptions(&buf2, opts)
+		r := slog.NewRecord(testTime, slog.LevelInfo, "m", 0)
+		r.AddAttrs(slog.Int("x", 4))
+		for _, h := range []slog.Handler{h, h.WithGroup("h")} {
+			buf1.Reset()
+			buf2.Reset()
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if err := fresh.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf1.String(), buf2.String(); got != want {
+				t.Errorf("after Reset: got %q, want %q", got, want)
+			}
+			fresh = fresh.WithGroup("h").(*Handler)
+		}
+		// Handlers derived before the reset keep their attributes.
+		buf1.Reset()
+		if err := h2.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf1.String(), "3") {
+			t.Errorf("derived handler lost its attributes: %q", buf1.String())
+		}
+	}
+}
+
+func TestHexKeys(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandlerWithOptions(&buf, Options{
+		HexKeys: map[string]bool{"addr": true, "g.off": true, "s": true, "n": true, "min": true},
+	})
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+	r.AddAttrs(
+		slog.Uint64("addr", 0xc000012345),
+		slog.Uint64("size", 255),
+		slog.Group("g", slog.Int("off", 255), slog.Int("addr", 16)),
+		slog.String("s", "x"),
+		slog.Int("n", -31),
+		slog.Int64("min", math.MinInt64),
+	)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=INFO msg=m addr=0xc000012345 size=255 g.off=0xff g.addr=16 s=x n=-0x1f min=-0x8000000000000000` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestReplaceBuiltins(t *testing.T) {
+	replacers := BuiltinReplacers{
+		Time: func(t time.Time) time.Time {
+			return t.Add(time.Hour)
+		},
+		Level: func(l slog.Level) string {
+			if l == slog.LevelInfo {
+				return "I"
+			}
+			return ""
+		},
+		Source: func(src string) string {
+			return "src:" + src[strings.LastIndexByte(src, ':')+1:]
+		},
+		Message: strings.ToUpper,
+	}
+	pc := callerPC(2)
+	line := recordFrame(slog.NewRecord(time.Time{}, 0, "", pc)).Line
+	for _, layout := range []Layout{LayoutText, LayoutProtoText} {
+		for _, replace := range []bool{false, true} {
+			var buf bytes.Buffer
+			opts := Options{
+				HandlerOptions:  slog.HandlerOptions{AddSource: true},
+				ReplaceBuiltins: replacers,
+				Layout:          layout,
+			}
+			if replace {
+				opts.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr { return a }
+			}
+			h := NewHandlerWithOptions(&buf, opts)
+			for _, l := range []slog.Level{slog.LevelInfo, slog.LevelWarn} {
+				r := slog.NewRecord(testTime, l, "hello", pc)
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := fmt.Sprintf("time=2000-01-02T04:04:05.000Z level=I source=src:%[1]d msg=HELLO\ntime=2000-01-02T04:04:05.000Z source=src:%[1]d msg=HELLO\n", line)
+			if layout == LayoutProtoText {
+				want = fmt.Sprintf("time: \"2000-01-02T04:04:05.000Z\" level: \"I\" source: \"src:%[1]d\" msg: \"HELLO\"\ntime: \"2000-01-02T04:04:05.000Z\" source: \"src:%[1]d\" msg: \"HELLO\"\n", line)
+			}
+			if got := buf.String(); got != want {
+				t.Errorf("layout %d, replace=%t:\ngot  %q\nwant %q", layout, replace, got, want)
+			}
+		}
+	}
+}
+
+func TestReplaceBuiltinsAlloc(t *testing.T) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	for i := 0; i < 10; i++ {
+		r.AddAttrs(slog.Int("x", i))
+	}
+	h := NewHandlerWithOptions(io.Discard, Options{
+		ReplaceBuiltins: BuiltinReplacers{
+			Level: func(l slog.Level) string { return "lvl" },
+		},
+	})
+	wantAllocs(t, 0, func() { h.Handle(context.Background(), r) })
+}
+
+func TestFlattenNewlines(t *testing.T) {
+	for _, test := range []struct {
+		opts Options
+		msg  string
+		want string
+	}{
+		{Options{}, "first\nsecond", `msg="first\nsecond" v="a\nb"`},
+		{Options{FlattenNewlines: "␤"}, "first\nsecond", `msg=first␤second v="a\nb"`},
+		{Options{FlattenNewlines: "␤"}, "line one\r\nline two\n", `msg="line one␤line two␤" v="a\nb"`},
+		{Options{FlattenNewlines: `\n`}, "first\nsecond", `msg=first\nsecond v="a\nb"`},
+		{Options{FlattenNewlines: "␤", FlattenValueNewlines: true}, "first\nsecond", `msg=first␤second v=a␤b`},
+	} {
+		var buf bytes.Buffer
+		h := NewHandlerWithOptions(&buf, test.opts)
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, test.msg, 0)
+		r.AddAttrs(slog.String("v", "a\nb"))
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		want := "level=INFO " + test.want + "\n"
+		if got := buf.String(); got != want {
+			t.Errorf("%q, %q: got %q, want %q", test.opts.FlattenNewlines, test.msg, got, want)
+		}
+	}
+}
+
+func TestJSONIndent(t *testing.T) {
+	type inner struct {
+		B []int
+	}
+	type outer struct {
+		A string
+		I inner
+	}
+	attrs := []slog.Attr{
+		slog.Any("s", outer{"x y", inner{[]int{1, 2}}}),
+		slog.Any("m", map[string]int{"k": 1}),
+		slog.Any("n", []int{}),
+		slog.Int("after", 1),
+	}
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "default",
+			want: `level=INFO msg=m s={"A":"x y","I":{"B":[1,2]}} m={"k":1} n=[] after=1` + "\n",
+		},
+		{
+			name: "indented",
+			opts: Options{JSONIndent: "  "},
+			want: `level=INFO msg=m s="{\n  \"A\": \"x y\",\n  \"I\": {\n    \"B\": [\n      1,\n      2\n    ]\n  }\n}" m="{\n  \"k\": 1\n}" n=[] after=1` + "\n",
+		},
+		{
+			name: "multiline",
+			opts: Options{JSONIndent: "\t", MultilineJSON: true},
+			want: "level=INFO msg=m s={\n" +
+				"| \t\"A\": \"x y\",\n" +
+				"| \t\"I\": {\n" +
+				"| \t\t\"B\": [\n" +
+				"| \t\t\t1,\n" +
+				"| \t\t\t2\n" +
+				"| \t\t]\n" +
+				"| \t}\n" +
+				"| } m={\n" +
+				"| \t\"k\": 1\n" +
+				"| } n=[] after=1\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &countingWriter{w: &buf}
+			h := NewHandlerWithOptions(w, test.opts)
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("\ngot  %s\nwant %s", got, test.want)
+			}
+			if w.n != 1 {
+				t.Errorf("got %d writes, want 1", w.n)
+			}
+		})
+	}
+}
+
+func TestGroupSeparator(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "default",
+			want: `level=INFO msg=m p.a=1 p.s.b.c.c=2 p.s.b.c.d.e=3 p.s.f=4 "p.s.g h.i"=5 p.s.j=6`,
+		},
+		{
+			name: "two characters",
+			opts: Options{GroupSeparator: "::"},
+			want: `level=INFO msg=m p::a=1 p::s::b.c::c=2 p::s::b.c::d::e=3 p::s::f=4 "p::s::g h::i"=5 p::s::j=6`,
+		},
+		{
+			name: "abbreviated",
+			opts: Options{GroupSeparator: "/", AbbreviateGroupPrefixes: true},
+			want: `level=INFO msg=m @1=p/s p/a=1 p/s/b.c/c=2 p/s/b.c/d/e=3 @1/f=4 "p/s/g h/i"=5 @1/j=6`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var gotKeys []string
+			opts := test.opts
+			opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key != slog.LevelKey && a.Key != slog.MessageKey {
+					gotKeys = append(gotKeys, JoinGroups(groups, a.Key, opts.GroupSeparator))
+				}
+				return a
+			}
+			var h slog.Handler = NewHandlerWithOptions(&buf, opts)
+			h = h.WithGroup("p").WithAttrs([]slog.Attr{slog.Int("a", 1)}).WithGroup("s")
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(
+				slog.Group("b.c", slog.Int("c", 2), slog.Group("d", slog.Int("e", 3))),
+				slog.Int("f", 4),
+				slog.Group("g h", slog.Int("i", 5)),
+				slog.Int("j", 6),
+			)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+			if opts.GroupSeparator != "" && !test.opts.AbbreviateGroupPrefixes {
+				want := []string{"p::a", "p::s::b.c::c", "p::s::b.c::d::e", "p::s::f", "p::s::g h::i", "p::s::j"}
+				if !slices.Equal(gotKeys, want) {
+					t.Errorf("JoinGroups gave keys %q, want %q", gotKeys, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeAdjacentGroups(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		merge bool
+		with  func(slog.Handler) slog.Handler
+		want  string
+	}{{
+		name: "double",
+		with: func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithGroup("g")
+		},
+		want: `level=INFO msg=m g.g.k=1`,
+	}, {
+		name:  "double merged",
+		merge: true,
+		with: func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithGroup("g")
+		},
+		want: `level=INFO msg=m g.k=1`,
+	}, {
+		name:  "attrs between",
+		merge: true,
+		with: func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithAttrs([]slog.Attr{slog.Int("a", 1)}).WithGroup("g").WithGroup("g")
+		},
+		want: `level=INFO msg=m g.a=1 g.k=1`,
+	}, {
+		name:  "distinct",
+		merge: true,
+		with: func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithGroup("h").WithGroup("g")
+		},
+		want: `level=INFO msg=m g.h.g.k=1`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := test.with(NewHandlerWithOptions(&buf, Options{MergeAdjacentGroups: test.merge}))
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0)
+			r.AddAttrs(slog.Int("k", 1))
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.want+"\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
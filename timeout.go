@@ -0,0 +1,72 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrWriteTimeout is returned by [Handler.Handle] when a write
+// does not complete within [Options.WriteTimeout], or when the record
+// is dropped because an earlier write that timed out is still
+// in progress.
+var ErrWriteTimeout = errors.New("slogtext: write timed out")
+
+// deadlineWriter is implemented by writers such as net.Conn
+// whose writes can be given a deadline.
+type deadlineWriter interface {
+	io.Writer
+	SetWriteDeadline(t time.Time) error
+}
+
+// writeWithTimeout writes p to w, giving up after the WriteTimeout.
+// It is called with h.mu held.
+func (h *Handler) writeWithTimeout(w io.Writer, p []byte) (int, error) {
+	timeout := h.opts.WriteTimeout
+	if dw, ok := w.(deadlineWriter); ok {
+		if err := dw.SetWriteDeadline(time.Now().Add(timeout)); err == nil {
+			n, err := dw.Write(p)
+			dw.SetWriteDeadline(time.Time{})
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = ErrWriteTimeout
+			}
+			return n, err
+		}
+	}
+	// Writes must not run concurrently, so drop the record
+	// while an abandoned write has yet to return. This also
+	// bounds the number of goroutines to one.
+	if h.stalled.Load() {
+		return 0, ErrWriteTimeout
+	}
+	// The caller will reuse p, but the write may outlive the call.
+	p = bytes.Clone(p)
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := w.Write(p)
+		done <- result{n, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-timer.C:
+		h.stalled.Store(true)
+		go func() {
+			<-done
+			h.stalled.Store(false)
+		}()
+		return 0, ErrWriteTimeout
+	}
+}
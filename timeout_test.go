@@ -0,0 +1,83 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// blockingWriter is a writer whose writes block until
+// release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestWriteTimeout(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewHandlerWithOptions(w, Options{WriteTimeout: 20 * time.Millisecond})
+	handle := func(msg string) error {
+		return h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, msg, 0))
+	}
+	t0 := time.Now()
+	if err := handle("first"); err != ErrWriteTimeout {
+		t.Fatalf("got error %v, want ErrWriteTimeout", err)
+	}
+	if d := time.Since(t0); d < 20*time.Millisecond {
+		t.Errorf("write timed out too early, after %v", d)
+	}
+	// While the first write is stuck, records are dropped at once.
+	t0 = time.Now()
+	if err := handle("dropped"); err != ErrWriteTimeout {
+		t.Fatalf("got error %v, want ErrWriteTimeout", err)
+	}
+	if d := time.Since(t0); d > 10*time.Millisecond {
+		t.Errorf("dropped record took %v", d)
+	}
+	close(w.release)
+	// Wait for the abandoned write to finish.
+	for h.stalled.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := handle("last"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "level=INFO msg=first\nlevel=INFO msg=last\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteTimeoutDeadline(t *testing.T) {
+	// A net.Conn whose reader never reads blocks once
+	// the pipe is full; net.Pipe has no buffering at all.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	h := NewHandlerWithOptions(c1, Options{WriteTimeout: 20 * time.Millisecond})
+	err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "m", 0))
+	if err != ErrWriteTimeout {
+		t.Fatalf("got error %v, want ErrWriteTimeout", err)
+	}
+	if h.stalled.Load() {
+		t.Errorf("deadline write left the handler stalled")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import "unicode/utf8"
+
+// defaultTruncationMarker is appended to values truncated
+// because of the MaxValueLen option when TruncationMarker
+// is empty.
+const defaultTruncationMarker = "…"
+
+// truncatedRecordMarker is appended to records truncated
+// because of the MaxRecordLen option.
+const truncatedRecordMarker = "… (truncated)"
+
+// truncateValueFrom truncates the value in the buffer from start
+// onwards if it is longer than MaxValueLen, not counting any quotes
+// and escapes, and re-quotes it if necessary.
+func (s *handleState) truncateValueFrom(start int) {
+	max := s.h.opts.MaxValueLen
+	if max <= 0 || len(*s.buf)-start <= max {
+		// A quoted value is never shorter than its contents.
+		return
+	}
+	b := (*s.buf)[start:]
+	str := string(b)
+	if b[0] == s.quoteChar() {
+		if u, err := unquoteWith(str, s.quoteChar()); err == nil {
+			str = u
+		}
+	}
+	if len(str) <= max {
+		return
+	}
+	marker := s.h.opts.TruncationMarker
+	if marker == "" {
+		marker = defaultTruncationMarker
+	}
+	*s.buf = (*s.buf)[:start]
+	s.appendString(truncateString(str, max) + marker)
+}
+
+// truncateString returns the longest prefix of str that is at most
+// max bytes long and does not split a UTF-8 sequence.
+func truncateString(str string, max int) string {
+	if len(str) <= max {
+		return str
+	}
+	for max > 0 && !utf8.RuneStart(str[max]) {
+		max--
+	}
+	return str[:max]
+}
+
+// truncateRecordFrom truncates the record in the buffer if it is
+// longer than MaxRecordLen, removing attributes after start from the
+// first one that does not fit along with the marker that replaces
+// them. The text before start is always kept, even if it is too long.
+// The last reserve bytes of the limit are left for an attribute that
+// the caller appends afterwards.
+func (s *handleState) truncateRecordFrom(start, reserve int) {
+	max := s.h.opts.MaxRecordLen
+	if max <= 0 {
+		return
+	}
+	if max -= reserve; len(*s.buf) <= max {
+		return
+	}
+	sepLen := 1
+	if fs := s.h.opts.FieldSeparator; fs != "" {
+		sepLen = len(fs)
+	}
+	// Cut at the separator before the start of an attribute, as
+	// recorded in s.fields, rather than looking for separators in
+	// the text, where they may also appear inside values or, with
+	// AlignKeys, as padding.
+	limit := max - sepLen - len(truncatedRecordMarker)
+	cut := start
+	for _, f := range s.fields {
+		if c := f.start - sepLen; c >= start && c <= limit && c < len(*s.buf) && c > cut {
+			cut = c
+		}
+	}
+	*s.buf = (*s.buf)[:cut]
+	s.appendFieldSep()
+	s.buf.WriteString(truncatedRecordMarker)
+}
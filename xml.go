@@ -0,0 +1,108 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package slogtext
+
+import (
+	"encoding/xml"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slog"
+)
+
+// appendXML appends a record element holding the given built-in
+// and other attributes to buf, as described by LayoutXML.
+// Values that cannot be formatted are written as errors
+// preceded by errPrefix.
+func appendXML(buf *buffer, builtins, attrs []slog.Attr, errPrefix string) {
+	buf.WriteString("<record")
+	var elems, tags []slog.Attr
+	for _, a := range builtins {
+		if a.Key == slog.MessageKey || a.Value.Kind() == slog.KindGroup {
+			elems = append([]slog.Attr{a}, elems...)
+			continue
+		}
+		if a.Key == TagKey {
+			// There may be several tags, but an XML element
+			// cannot have repeated attributes.
+			tags = append(tags, a)
+			continue
+		}
+		buf.WriteByte(' ')
+		appendXMLName(buf, a.Key)
+		buf.WriteString(`="`)
+		appendXMLValue(buf, a.Value, errPrefix)
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	appendXMLElements(buf, elems, errPrefix)
+	appendXMLElements(buf, tags, errPrefix)
+	appendXMLElements(buf, attrs, errPrefix)
+	buf.WriteString("</record>")
+}
+
+func appendXMLElements(buf *buffer, attrs []slog.Attr, errPrefix string) {
+	for _, a := range attrs {
+		buf.WriteByte('<')
+		appendXMLName(buf, a.Key)
+		buf.WriteByte('>')
+		if a.Value.Kind() == slog.KindGroup {
+			appendXMLElements(buf, a.Value.Group(), errPrefix)
+		} else {
+			appendXMLValue(buf, a.Value, errPrefix)
+		}
+		buf.WriteString("</")
+		appendXMLName(buf, a.Key)
+		buf.WriteByte('>')
+	}
+}
+
+// appendXMLValue appends the escaped text of v, which
+// is suitable both for element content and for
+// a quoted attribute value.
+func appendXMLValue(buf *buffer, v slog.Value, errPrefix string) {
+	var s string
+	switch v.Kind() {
+	case slog.KindInt64:
+		*buf = strconv.AppendInt(*buf, v.Int64(), 10)
+		return
+	case slog.KindUint64:
+		*buf = strconv.AppendUint(*buf, v.Uint64(), 10)
+		return
+	case slog.KindFloat64:
+		*buf = strconv.AppendFloat(*buf, v.Float64(), 'g', -1, 64)
+		return
+	case slog.KindBool:
+		*buf = strconv.AppendBool(*buf, v.Bool())
+		return
+	case slog.KindTime:
+		writeTimeRFC3339Millis(buf, v.Time())
+		return
+	case slog.KindAny, slog.KindLogValuer:
+		s = anyString(v.Any(), errPrefix)
+	default:
+		// Strings and durations.
+		s = v.String()
+	}
+	// EscapeText escapes quotes as well as '<', '>' and '&',
+	// and only fails if the writer does.
+	xml.EscapeText(buf, []byte(s))
+}
+
+// appendXMLName appends key to buf as a valid XML name,
+// replacing invalid characters with underscores.
+func appendXMLName(buf *buffer, key string) {
+	for i, r := range key {
+		ok := r == '_' || unicode.IsLetter(r)
+		if i > 0 {
+			ok = ok || r == '-' || r == '.' || unicode.IsDigit(r)
+		}
+		if !ok {
+			r = '_'
+		}
+		*buf = utf8.AppendRune(*buf, r)
+	}
+}
@@ -0,0 +1,81 @@
+package slogtext
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestLayoutXML(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		with  func(slog.Handler) slog.Handler
+		msg   string
+		attrs []slog.Attr
+		want  string
+	}{
+		{
+			name: "scalars",
+			msg:  "m",
+			attrs: []slog.Attr{
+				slog.String("a", "one"),
+				slog.Int("b", -2),
+				slog.Bool("c", true),
+				slog.Duration("d", time.Second),
+				slog.Any("e", map[string]int{"k": 1}),
+			},
+			want: `<record time="2000-01-02T03:04:05.000Z" level="INFO"><msg>m</msg><a>one</a><b>-2</b><c>true</c><d>1s</d><e>{&#34;k&#34;:1}</e></record>`,
+		},
+		{
+			name: "nested groups",
+			msg:  "m",
+			with: func(h slog.Handler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.Int("p", 1)}).WithGroup("s")
+			},
+			attrs: []slog.Attr{
+				slog.Group("g", slog.Int("a", 1), slog.Group("h", slog.String("b", "x"))),
+				slog.Group("empty"),
+				slog.Int("bad key", 2),
+			},
+			want: `<record time="2000-01-02T03:04:05.000Z" level="INFO"><msg>m</msg><p>1</p><s><g><a>1</a><h><b>x</b></h></g><bad_key>2</bad_key></s></record>`,
+		},
+		{
+			name:  "special characters",
+			msg:   `<a & "b">`,
+			attrs: []slog.Attr{slog.String("x", "it's 1 < 2 & 3 > 2\n")},
+			want:  `<record time="2000-01-02T03:04:05.000Z" level="INFO"><msg>&lt;a &amp; &#34;b&#34;&gt;</msg><x>it&#39;s 1 &lt; 2 &amp; 3 &gt; 2&#xA;</x></record>`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var h slog.Handler = NewHandlerWithOptions(&buf, Options{Layout: LayoutXML})
+			if test.with != nil {
+				h = test.with(h)
+			}
+			r := slog.NewRecord(testTime, slog.LevelInfo, test.msg, 0)
+			r.AddAttrs(test.attrs...)
+			if err := h.Handle(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.String()
+			if want := test.want + "\n"; got != want {
+				t.Errorf("\ngot  %s\nwant %s", got, want)
+			}
+			// The output must be well formed.
+			var v struct {
+				Level string `xml:"level,attr"`
+				Msg   string `xml:"msg"`
+			}
+			if err := xml.Unmarshal([]byte(got), &v); err != nil {
+				t.Fatalf("invalid XML: %v", err)
+			}
+			if v.Level != "INFO" || v.Msg != test.msg {
+				t.Errorf("got level %q, msg %q", v.Level, v.Msg)
+			}
+		})
+	}
+}